@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+// Log output buffer that can be read while the hub and the test server still log
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestAccessLog(t *testing.T) {
+	// Test that requests are logged with status and without query tokens, and WebSockets still upgrade
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.accessLog = true
+	a.config.shareToken = "sharetoken"
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	ts := httptest.NewServer(a.routes())
+
+	resp, err := http.Get(ts.URL + "/api/history")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?token="+a.config.shareToken, nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial through access log failed: %v", err)
+	}
+	expectMeta(t, c)
+	c.Close()
+	ts.Close()
+
+	output := buf.String()
+	if !strings.Contains(output, "GET /api/history 401 ") {
+		t.Fatalf("Expected access log line for unauthorized request, got: %s", output)
+	}
+	if !strings.Contains(output, "GET /ws 101 ") {
+		t.Fatalf("Expected access log line for WebSocket upgrade, got: %s", output)
+	}
+	if strings.Contains(output, a.config.shareToken) {
+		t.Fatalf("Token leaked into access log: %s", output)
+	}
+}
+
+func TestAccessLogHijack(t *testing.T) {
+	// Test that a handler hijacking the connection without writing a header is logged as switching protocols
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.accessLog = true
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	upgrader := gwss.Upgrader{}
+	ts := httptest.NewServer(a.accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := upgrader.Upgrade(w, r, nil); err == nil {
+			c.Close()
+		}
+	})))
+	defer ts.Close()
+
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/raw", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial through access log failed: %v", err)
+	}
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+	if output := buf.String(); !strings.Contains(output, "GET /raw 101 ") {
+		t.Fatalf("Expected access log line with status 101, got: %s", output)
+	}
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	// Test that nothing is logged per request when the access log is disabled
+	a := setupTestApp(t)
+	defer a.db.Close()
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if strings.Contains(buf.String(), "GET /health") {
+		t.Fatalf("Unexpected access log output: %s", buf.String())
+	}
+}
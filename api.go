@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Helper to write a value as JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// Helper to get a query parameter by its snake_case name or its camelCase alias, the first one set wins
+func queryParam(query url.Values, names ...string) string {
+	for _, name := range names {
+		if s := query.Get(name); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Default map tiles of the web interface
+const (
+	defaultTileURL         = "https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png"
+	defaultTileAttribution = "© OpenStreetMap contributors"
+)
+
+// Frontend settings returned by the config endpoint
+type frontendConfig struct {
+	AppName         string  `json:"app_name"`
+	CenterLat       float64 `json:"center_lat"`
+	CenterLon       float64 `json:"center_lon"`
+	Zoom            int64   `json:"zoom"`
+	HistorySeconds  int64   `json:"history_seconds"`
+	TileURL         string  `json:"tile_url"`
+	TileAttribution string  `json:"tile_attribution"`
+	// Whether /api/place is available
+	Geocode bool `json:"geocode"`
+}
+
+// Helper to check that a tile URL template contains the tile coordinate placeholders
+func validTileURL(template string) bool {
+	return strings.Contains(template, "{z}") && strings.Contains(template, "{x}") && strings.Contains(template, "{y}")
+}
+
+func (a *app) configHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the settings the web interface uses for its initial view
+	writeJSON(w, http.StatusOK, frontendConfig{
+		AppName:         a.config.appName,
+		CenterLat:       a.config.mapCenterLat,
+		CenterLon:       a.config.mapCenterLon,
+		Zoom:            a.config.mapZoom,
+		HistorySeconds:  a.config.historySeconds,
+		TileURL:         a.config.tileURL,
+		TileAttribution: a.config.tileAttribution,
+		Geocode:         a.geocoder != nil,
+	})
+}
+
+func (a *app) historyHandler(w http.ResponseWriter, r *http.Request) {
+	// Return location history as a JSON array, defaulting to the configured history window
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var fromMs, toMs int64
+	if from != nil {
+		fromMs = *from
+	} else {
+		fromMs = time.Now().Add(-time.Duration(a.config.historySeconds) * time.Second).UnixMilli()
+	}
+	if to != nil {
+		toMs = *to
+	}
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts, err := parseDownsampleOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	box, err := parseBoundingBox(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	colorBy := queryParam(query, "colorBy", "color_by")
+	if colorBy != "" && colorBy != "speed" {
+		http.Error(w, "invalid colorBy", http.StatusBadRequest)
+		return
+	}
+
+	units, err := parseUnits(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := a.queryLocations(r.Context(), fromMs, 0, toMs, box, limit)
+	if err != nil {
+		log.Printf("Error fetching history: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	points = units.convertAll(downsample(points, opts))
+	if colorBy == "speed" {
+		writeJSON(w, http.StatusOK, withSpeedPercentages(points))
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// Location point with its speed relative to the other returned points, null without a speed
+type speedColoredPoint struct {
+	locationPoint
+	SpeedPct *float64 `json:"speedPct"`
+}
+
+// Helper to scale the speed of each point to 0-1 between the minimum and maximum speed of all points,
+// all speeds are 0 when they are equal
+func withSpeedPercentages(points []locationPoint) []speedColoredPoint {
+	minSpeed, maxSpeed := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		if p.Speed != nil {
+			minSpeed, maxSpeed = min(minSpeed, *p.Speed), max(maxSpeed, *p.Speed)
+		}
+	}
+	colored := make([]speedColoredPoint, len(points))
+	for i, p := range points {
+		colored[i].locationPoint = p
+		if p.Speed == nil {
+			continue
+		}
+		pct := 0.0
+		if maxSpeed > minSpeed {
+			pct = (*p.Speed - minSpeed) / (maxSpeed - minSpeed)
+		}
+		colored[i].SpeedPct = &pct
+	}
+	return colored
+}
+
+// Helper to query the most recent location, of a single device unless device is empty
+func (a *app) queryLastLocation(ctx context.Context, device string) (locationPoint, error) {
+	query := "SELECT " + locationColumns + " FROM locations"
+	var args []any
+	if device != "" {
+		query += " WHERE device_id = ?"
+		args = append(args, device)
+	}
+	query += " ORDER BY timestamp DESC LIMIT 1"
+
+	ctx, cancel := a.dbContext(ctx)
+	defer cancel()
+	return scanLocation(a.reader().QueryRowContext(ctx, query, args...))
+}
+
+func (a *app) lastLocationHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the most recent location, optionally of a single device, or 204 if there is none
+	p, err := a.queryLastLocation(r.Context(), r.URL.Query().Get("device"))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching last location: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// Helper to parse an optional bounding box from min_lat, max_lat, min_lon and max_lon,
+// returns nil when none of the parameters are set
+func parseBoundingBox(query url.Values) (*[4]float64, error) {
+	keys := [4]string{"min_lat", "max_lat", "min_lon", "max_lon"}
+	aliases := [4]string{"minLat", "maxLat", "minLon", "maxLon"}
+	var box [4]float64
+	present := 0
+	for i, key := range keys {
+		s := queryParam(query, key, aliases[i])
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", key)
+		}
+		box[i] = v
+		present++
+	}
+	switch present {
+	case 0:
+		return nil, nil
+	case len(keys):
+		if !validBoundingBox(box) {
+			return nil, errors.New("bounding box requires min_lat < max_lat and min_lon < max_lon")
+		}
+		return &box, nil
+	default:
+		return nil, errors.New("bounding box requires min_lat, max_lat, min_lon and max_lon")
+	}
+}
+
+// Helper to check that the minimum of both axes of a bounding box is below the maximum
+func validBoundingBox(box [4]float64) bool {
+	return box[0] < box[1] && box[2] < box[3]
+}
+
+func (a *app) deleteLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Delete locations within a time range and/or bounding box
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (from == nil) != (to == nil) {
+		http.Error(w, "Both from and to are required", http.StatusBadRequest)
+		return
+	}
+	box, err := parseBoundingBox(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from == nil && box == nil {
+		http.Error(w, "A time range (from, to) or bounding box is required", http.StatusBadRequest)
+		return
+	}
+
+	where, args := timeRangeClause(from, to)
+	if box != nil {
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += "latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?"
+		args = append(args, box[0], box[1], box[2], box[3])
+	}
+
+	ctx, cancel := a.dbContext(r.Context())
+	defer cancel()
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error beginning delete transaction: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM locations"+where, args...)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error deleting locations: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	deleted, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing delete: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted %d locations", deleted)
+	a.hub.recent.reset()
+	a.hub.send(hubMessage{Type: "deleted", Payload: map[string]int64{"deleted": deleted}})
+	a.hub.broadcastControl(resetMessage{Type: "reset", Reason: "deleted", Reload: true})
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
+}
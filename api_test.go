@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	// Test that /api/history returns a JSON array honoring from/to/limit
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.historyHandler))
+	defer srv.Close()
+
+	get := func(query string) (int, string, []locationPoint) {
+		resp, err := http.Get(srv.URL + "/api/history?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		var points []locationPoint
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+				t.Fatalf("Invalid JSON: %v", err)
+			}
+			json.Unmarshal(raw, &points)
+		}
+		return resp.StatusCode, string(raw), points
+	}
+
+	if status, raw, _ := get(""); status != http.StatusOK || raw != "[]" {
+		t.Fatalf("Expected empty array, got %d %s", status, raw)
+	}
+	for _, ts := range []int64{1000, 2000, 3000, 4000} {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	_, _, points := get("from=2000&to=4000&limit=2")
+	if len(points) != 2 || points[0].Timestamp != 2000 || points[1].Timestamp != 3000 {
+		t.Fatalf("Unexpected points: %+v", points)
+	}
+	if status, _, _ := get("limit=abc"); status != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", status)
+	}
+}
+
+func TestHistoryHandlerColorBySpeed(t *testing.T) {
+	// Test that colorBy=speed scales speeds between the minimum and maximum and keeps missing speeds null
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.historyHandler))
+	defer srv.Close()
+	for i, speed := range []any{2.0, nil, 10.0, 4.0} {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, speed, nil, nil, int64(i+1)*1000, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/api/history?from=1&colorBy=speed")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var points []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&points); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(points) != 4 {
+		t.Fatalf("Expected 4 points, got %+v", points)
+	}
+	for i, want := range []any{0.0, nil, 1.0, 0.25} {
+		got, ok := points[i]["speedPct"]
+		if !ok || got != want {
+			t.Fatalf("Expected speedPct %v for point %d, got %v", want, i, points[i])
+		}
+	}
+	if points[0]["lat"] != 1.0 || points[0]["timestamp"] != 1000.0 {
+		t.Fatalf("Expected location fields next to speedPct, got %v", points[0])
+	}
+
+	resp, err = http.Get(srv.URL + "/api/history?color_by=altitude")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for unknown colorBy, got %d", resp.StatusCode)
+	}
+}
+
+func TestHistoryHandlerBoundingBox(t *testing.T) {
+	// Test that /api/history only returns points within a valid bounding box
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.historyHandler))
+	defer srv.Close()
+	for i, ts := range []int64{1000, 2000, 3000, 4000} {
+		if _, err := a.insertLocationStmt.Exec(float64(i), float64(i), nil, nil, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	get := func(query string) (int, []locationPoint) {
+		resp, err := http.Get(srv.URL + "/api/history?from=1&" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var points []locationPoint
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&points); err != nil {
+				t.Fatalf("Invalid JSON: %v", err)
+			}
+		}
+		return resp.StatusCode, points
+	}
+
+	status, points := get("min_lat=0.5&max_lat=2.5&min_lon=0.5&max_lon=2.5")
+	if status != http.StatusOK || len(points) != 2 || points[0].Timestamp != 2000 || points[1].Timestamp != 3000 {
+		t.Fatalf("Unexpected points within box: %d %+v", status, points)
+	}
+	if status, points := get("minLat=0.5&maxLat=2.5&minLon=0.5&maxLon=2.5"); status != http.StatusOK || len(points) != 2 {
+		t.Fatalf("Expected the camelCase box to be accepted, got %d %+v", status, points)
+	}
+	if status, points := get("min_lat=0.5&max_lat=2.5&min_lon=2.5&max_lon=3.5&to=4000"); status != http.StatusOK || len(points) != 0 {
+		t.Fatalf("Expected no points matching both axes, got %d %+v", status, points)
+	}
+	for _, query := range []string{"min_lat=1", "minLat=1", "min_lat=2&max_lat=1&min_lon=0&max_lon=1", "min_lat=0&max_lat=1&min_lon=1&max_lon=1", "min_lat=x&max_lat=1&min_lon=0&max_lon=1"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %q, got %d", query, status)
+		}
+	}
+}
+
+func TestDeleteLocationsHandler(t *testing.T) {
+	// Test that locations are deleted by time range and bounding box only when bounds are complete
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.deleteLocationsHandler))
+	defer srv.Close()
+	for i, ts := range []int64{1000, 2000, 3000, 4000} {
+		if _, err := a.insertLocationStmt.Exec(float64(i), float64(i), nil, nil, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	del := func(query string) (int, int64) {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/locations?"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]int64
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result["deleted"]
+	}
+
+	for _, query := range []string{"", "from=1000", "min_lat=0&max_lat=1", "from=x&to=1"} {
+		if status, _ := del(query); status != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %q, got %d", query, status)
+		}
+	}
+	if status, deleted := del("from=1500&to=2500"); status != http.StatusOK || deleted != 1 {
+		t.Fatalf("Expected 1 deleted by time range, got %d %d", status, deleted)
+	}
+	if status, deleted := del("min_lat=1.5&max_lat=10&min_lon=1.5&max_lon=10"); status != http.StatusOK || deleted != 2 {
+		t.Fatalf("Expected 2 deleted by bounding box, got %d %d", status, deleted)
+	}
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected 1 remaining row: %v, count=%d", err, count)
+	}
+}
+
+func TestDeleteLocationsResetsClients(t *testing.T) {
+	// Test that deleting locations sends the deleted message and tells clients to clear their view and reload the history
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ws := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ws.Close()
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer c.Close()
+	expectMeta(t, c)
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	a.deleteLocationsHandler(rec, httptest.NewRequest(http.MethodDelete, "/api/locations?from=0&to=1000", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var msg struct {
+		Type    string           `json:"type"`
+		Payload map[string]int64 `json:"payload"`
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := c.ReadJSON(&msg); err != nil || msg.Type != "deleted" || msg.Payload["deleted"] != 0 {
+		t.Fatalf("Expected deleted message, got %+v (%v)", msg, err)
+	}
+	var reset resetMessage
+	if err := c.ReadJSON(&reset); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if reset.Type != "reset" || reset.Reason != "deleted" || reset.DeviceID != "" || !reset.Reload {
+		t.Fatalf("Unexpected reset message: %+v", reset)
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	// Test that /api/config returns the configured map view and app name and requires authentication
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.appName = "Tracker B"
+	a.config.mapCenterLat, a.config.mapCenterLon, a.config.mapZoom = 48.1, 11.6, 10
+	a.config.tileURL, a.config.tileAttribution = "https://tiles.example.com/{z}/{x}/{y}.png?key=abc", "© Example"
+	srv := httptest.NewServer(a.routes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/config")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %d", resp.StatusCode)
+	}
+	if realm := resp.Header.Get("WWW-Authenticate"); realm != `Basic realm="Tracker B"` {
+		t.Fatalf("Expected the app name as realm, got %q", realm)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/config", nil)
+	req.SetBasicAuth(a.config.user, a.config.pass)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var cfg frontendConfig
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if cfg.CenterLat != 48.1 || cfg.CenterLon != 11.6 || cfg.Zoom != 10 || cfg.HistorySeconds != a.config.historySeconds {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+	if cfg.TileURL != a.config.tileURL || cfg.TileAttribution != "© Example" {
+		t.Fatalf("Unexpected tile config: %+v", cfg)
+	}
+	if cfg.AppName != "Tracker B" {
+		t.Fatalf("Expected app name Tracker B, got %q", cfg.AppName)
+	}
+}
+
+func TestValidTileURL(t *testing.T) {
+	// Test that tile URL templates need all coordinate placeholders
+	if !validTileURL(defaultTileURL) {
+		t.Fatal("Default tile URL should be valid")
+	}
+	if !validTileURL("http://localhost:8081/tiles/{z}/{x}/{y}@2x.png") {
+		t.Fatal("Self-hosted tile URL should be valid")
+	}
+	for _, tmpl := range []string{"", "https://tiles.example.com/{z}/{x}.png", "https://tiles.example.com/{x}/{y}.png"} {
+		if validTileURL(tmpl) {
+			t.Fatalf("Expected %q to be invalid", tmpl)
+		}
+	}
+}
+
+func TestLastLocationHandler(t *testing.T) {
+	// Test that /api/last returns the newest point, filtered by device, and 204 without data
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.lastLocationHandler))
+	defer srv.Close()
+
+	get := func(query string) (int, locationPoint) {
+		resp, err := http.Get(srv.URL + "/api/last?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var p locationPoint
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+				t.Fatalf("Invalid JSON: %v", err)
+			}
+		}
+		return resp.StatusCode, p
+	}
+
+	if status, _ := get(""); status != http.StatusNoContent {
+		t.Fatalf("Expected 204 without data, got %d", status)
+	}
+	a.insertLocationStmt.Exec(1.0, 1.0, nil, nil, nil, nil, 1000, "phone", false, nil, nil, false, nil)
+	a.insertLocationStmt.Exec(2.0, 2.0, nil, nil, nil, nil, 3000, "bike", false, nil, nil, false, nil)
+	a.insertLocationStmt.Exec(3.0, 3.0, nil, nil, nil, nil, 2000, "phone", false, nil, nil, false, nil)
+
+	if status, p := get(""); status != http.StatusOK || p.Timestamp != 3000 || p.DeviceID != "bike" {
+		t.Fatalf("Expected newest bike point, got %d %+v", status, p)
+	}
+	if status, p := get("device=phone"); status != http.StatusOK || p.Timestamp != 2000 || p.Latitude != 3 {
+		t.Fatalf("Expected newest phone point, got %d %+v", status, p)
+	}
+	if status, _ := get("device=car"); status != http.StatusNoContent {
+		t.Fatalf("Expected 204 for unknown device, got %d", status)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Default and maximum number of points returned on each side of the requested time
+const (
+	defaultAroundPoints = 10
+	maxAroundPoints     = 1000
+)
+
+// Helper to parse the number of points of one side, missing values use the default
+func parseAroundCount(s string) (int, bool) {
+	if s == "" {
+		return defaultAroundPoints, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return min(n, maxAroundPoints), true
+}
+
+func (a *app) aroundHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the points just before and after a timestamp ordered by time, e.g. for scrubbing through a trip
+	query := r.URL.Query()
+	ts, err := strconv.ParseInt(query.Get("ts"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid ts", http.StatusBadRequest)
+		return
+	}
+	before, ok := parseAroundCount(query.Get("before"))
+	if !ok {
+		http.Error(w, "invalid before", http.StatusBadRequest)
+		return
+	}
+	after, ok := parseAroundCount(query.Get("after"))
+	if !ok {
+		http.Error(w, "invalid after", http.StatusBadRequest)
+		return
+	}
+
+	points, err := a.queryAround(r.Context(), ts, before, after, query.Get("device"))
+	if err != nil {
+		log.Printf("Error fetching points around %d: %v", ts, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// Query up to before points at or before ts and up to after points after it, ordered by timestamp.
+// Both sides are separate queries walking the timestamp index from ts, fewer points are returned
+// near the start or end of the track.
+func (a *app) queryAround(ctx context.Context, ts int64, before, after int, device string) ([]locationPoint, error) {
+	ctx, cancel := a.dbContext(ctx)
+	defer cancel()
+	side := func(condition, order string, limit int) ([]locationPoint, error) {
+		points := []locationPoint{}
+		if limit == 0 {
+			return points, nil
+		}
+		query := "SELECT " + locationColumns + " FROM locations WHERE " + condition
+		args := []any{ts}
+		if device != "" {
+			query += " AND device_id = ?"
+			args = append(args, device)
+		}
+		query += " ORDER BY timestamp " + order + " LIMIT ?"
+		args = append(args, limit)
+		rows, err := a.reader().QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			p, err := scanLocation(rows)
+			if err != nil {
+				log.Printf("Error scanning location row: %v", err)
+				continue
+			}
+			points = append(points, p)
+		}
+		return points, rows.Err()
+	}
+
+	earlier, err := side("timestamp <= ?", "DESC", before)
+	if err != nil {
+		return nil, err
+	}
+	later, err := side("timestamp > ?", "ASC", after)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(earlier)
+	return append(earlier, later...), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAroundHandler(t *testing.T) {
+	// Test that the points around a timestamp are returned in order, with fewer points near the boundaries
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for _, ts := range []int64{5000, 1000, 3000, 2000, 4000} {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, ts, "phone", false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, 3500, "bike", false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	get := func(query string) []int64 {
+		rec := httptest.NewRecorder()
+		a.aroundHandler(rec, httptest.NewRequest(http.MethodGet, "/api/around?"+query, nil))
+		var points []locationPoint
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &points) != nil {
+			t.Fatalf("Expected points for %s, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		timestamps := make([]int64, len(points))
+		for i, p := range points {
+			timestamps[i] = p.Timestamp
+		}
+		return timestamps
+	}
+
+	// The point at the timestamp itself counts as before
+	if got := get("ts=3000&before=2&after=2&device=phone"); !slices.Equal(got, []int64{2000, 3000, 4000, 5000}) {
+		t.Fatalf("Unexpected points around 3000: %v", got)
+	}
+	if got := get("ts=3000&before=1&after=1"); !slices.Equal(got, []int64{3000, 3500}) {
+		t.Fatalf("Unexpected points of all devices: %v", got)
+	}
+	if got := get("ts=1500&before=5&after=0&device=phone"); !slices.Equal(got, []int64{1000}) {
+		t.Fatalf("Unexpected points near the start: %v", got)
+	}
+	if got := get("ts=9000&device=phone"); !slices.Equal(got, []int64{1000, 2000, 3000, 4000, 5000}) {
+		t.Fatalf("Unexpected points after the end: %v", got)
+	}
+
+	for _, query := range []string{"", "ts=x", "ts=1000&before=-1", "ts=1000&after=y"} {
+		rec := httptest.NewRecorder()
+		a.aroundHandler(rec, httptest.NewRequest(http.MethodGet, "/api/around?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %q, got %d", query, rec.Code)
+		}
+	}
+}
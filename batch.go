@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Returned for points arriving after the batch writer was closed on shutdown
+var errShuttingDown = errors.New("shutting down")
+
+// Write-behind buffer that inserts location points in batched transactions
+type batchWriter struct {
+	db       *sql.DB
+	stmt     *sql.Stmt
+	size     int
+	interval time.Duration
+	points   chan locationPoint
+	done     chan struct{}
+	// Held for reading while a point is queued, so close never closes the channel under a sender
+	mutex  sync.RWMutex
+	closed bool
+	// Receives the outcome of every flush, optional
+	health *writeHealth
+}
+
+func newBatchWriter(db *sql.DB, stmt *sql.Stmt, size int, interval time.Duration) *batchWriter {
+	return &batchWriter{
+		db:       db,
+		stmt:     stmt,
+		size:     size,
+		interval: interval,
+		points:   make(chan locationPoint, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Queue a point for the next flush, fails once the writer is closed
+func (b *batchWriter) add(p locationPoint) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if b.closed {
+		return errShuttingDown
+	}
+	b.points <- p
+	return nil
+}
+
+func (b *batchWriter) run() {
+	// Accumulate points and flush them when the batch is full or the interval elapsed
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	buffer := make([]locationPoint, 0, b.size)
+	for {
+		select {
+		case p, ok := <-b.points:
+			if !ok {
+				b.flush(buffer)
+				return
+			}
+			buffer = append(buffer, p)
+			if len(buffer) >= b.size {
+				b.flush(buffer)
+				buffer = buffer[:0]
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				b.flush(buffer)
+				buffer = buffer[:0]
+			}
+		}
+	}
+}
+
+// Stop accepting points and wait until the pending buffer is flushed
+func (b *batchWriter) close() {
+	b.mutex.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.points)
+	}
+	b.mutex.Unlock()
+	<-b.done
+}
+
+func (b *batchWriter) flush(points []locationPoint) {
+	// Insert all buffered points in a single transaction
+	if len(points) == 0 {
+		return
+	}
+	err := insertLocationsTx(b.db, b.stmt, points)
+	b.health.record(err)
+	if err != nil {
+		log.Printf("Error saving batch, dropping %d locations: %v", len(points), err)
+		return
+	}
+	log.Printf("Flushed batch of %d locations", len(points))
+}
+
+// Insert multiple points in a single transaction using the given insert statement
+func insertLocationsTx(db *sql.DB, insertStmt *sql.Stmt, points []locationPoint) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt := tx.Stmt(insertStmt)
+	for _, p := range points {
+		if err := insertLocation(context.Background(), stmt, p); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter(t *testing.T) {
+	// Test that batched points are flushed when the batch is full and on close
+	a := setupTestApp(t)
+	defer a.db.Close()
+	b := newBatchWriter(a.db, a.insertLocationStmt, 2, time.Hour)
+	go b.run()
+
+	count := func() int {
+		var n int
+		if err := a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&n); err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		return n
+	}
+	for i := range 3 {
+		b.add(locationPoint{Latitude: 1, Longitude: 2, Timestamp: int64(i), DeviceID: defaultDeviceID})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("Expected 2 rows after full batch, got %d", n)
+	}
+	b.close()
+	if n := count(); n != 3 {
+		t.Fatalf("Expected 3 rows after close, got %d", n)
+	}
+	if err := b.add(locationPoint{Latitude: 1, Longitude: 2, Timestamp: 4, DeviceID: defaultDeviceID}); !errors.Is(err, errShuttingDown) {
+		t.Fatalf("Expected errShuttingDown after close, got %v", err)
+	}
+	b.close()
+}
+
+func TestTrackHandler_AfterBatchClosed(t *testing.T) {
+	// Test that a location arriving after the batch writer closed on shutdown is answered with 503
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.batch = newBatchWriter(a.db, a.insertLocationStmt, 10, time.Hour)
+	go a.batch.run()
+	a.batch.close()
+
+	rec := httptest.NewRecorder()
+	a.trackHandler(rec, httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp=1000", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+}
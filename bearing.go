@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// Initial bearing in degrees (0-360, clockwise from north) on the great circle from the first to the second coordinate
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	phi1, phi2 := toRad(lat1), toRad(lat2)
+	dLon := toRad(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// Last received point per device, used to derive missing bearings
+type bearingTracker struct {
+	mutex sync.Mutex
+	last  map[string]locationPoint
+}
+
+// Fill in a missing bearing from the previous point of the same device and remember the point
+func (a *app) deriveBearing(p *locationPoint) {
+	if !a.config.deriveBearing {
+		return
+	}
+	a.bearingState.mutex.Lock()
+	defer a.bearingState.mutex.Unlock()
+	if a.bearingState.last == nil {
+		a.bearingState.last = make(map[string]locationPoint)
+	}
+	prev, ok := a.bearingState.last[p.DeviceID]
+	// Points arriving out of order neither get a bearing nor replace the newer previous point
+	if ok && p.Timestamp <= prev.Timestamp {
+		return
+	}
+	a.bearingState.last[p.DeviceID] = *p
+	// Without movement there is no direction
+	if p.Bearing != nil || !ok || (prev.Latitude == p.Latitude && prev.Longitude == p.Longitude) {
+		return
+	}
+	bearing := initialBearing(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+	p.Bearing = &bearing
+	p.BearingDerived = true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestInitialBearing(t *testing.T) {
+	// Test the initial bearing for the cardinal directions and a known route
+	for _, tc := range []struct {
+		lat1, lon1, lat2, lon2, expected float64
+	}{
+		{0, 0, 1, 0, 0},
+		{0, 0, 0, 1, 90},
+		{1, 0, 0, 0, 180},
+		{0, 1, 0, 0, 270},
+		// Baghdad to Osaka
+		{35, 45, 35, 135, 60.16},
+	} {
+		if got := initialBearing(tc.lat1, tc.lon1, tc.lat2, tc.lon2); math.Abs(got-tc.expected) > 0.01 {
+			t.Fatalf("For %+v expected %.2f, got %.2f", tc, tc.expected, got)
+		}
+	}
+}
+
+func TestDeriveBearing(t *testing.T) {
+	// Test that missing bearings are derived from the previous point of the same device
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.deriveBearing = true
+
+	points := []locationPoint{
+		{Latitude: 0, Longitude: 0, Timestamp: 1000, DeviceID: "phone"},
+		{Latitude: 0, Longitude: 0, Timestamp: 1000, DeviceID: "bike"},
+		{Latitude: 0, Longitude: 1, Timestamp: 2000, DeviceID: "phone"},
+		{Latitude: 1, Longitude: 0, Timestamp: 2000, DeviceID: "bike"},
+		{Latitude: 0, Longitude: 1, Timestamp: 3000, DeviceID: "phone"},
+	}
+	reported := 42.0
+	points = append(points, locationPoint{Latitude: 1, Longitude: 1, Timestamp: 4000, Bearing: &reported, DeviceID: "phone"})
+	for _, p := range points {
+		if _, err := a.storeLocation(context.Background(), p); err != nil {
+			t.Fatalf("Storing location failed: %v", err)
+		}
+	}
+
+	stored, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	expected := map[string][]*float64{
+		"phone": {nil, ptr(90.0), nil, ptr(42.0)},
+		"bike":  {nil, ptr(0.0)},
+	}
+	for _, p := range stored {
+		want := expected[p.DeviceID][0]
+		expected[p.DeviceID] = expected[p.DeviceID][1:]
+		if (want == nil) != (p.Bearing == nil) || (want != nil && math.Abs(*want-*p.Bearing) > 1e-9) {
+			t.Fatalf("Unexpected bearing for %+v", p)
+		}
+		if p.BearingDerived != (want != nil && *want != reported) {
+			t.Fatalf("Unexpected derived flag for %+v", p)
+		}
+	}
+}
+
+// Helper to get a pointer to a value
+func ptr[T any](v T) *T {
+	return &v
+}
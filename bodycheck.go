@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Problems found in a tracking request body, answered with 400 so integrations see everything
+// that is wrong at once instead of fixing one field after the other
+type bodyProblems struct {
+	Error    string   `json:"error"`
+	Problems []string `json:"problems"`
+}
+
+// Helper to answer a tracking request whose body has problems
+func writeBodyProblems(w http.ResponseWriter, problems []string) {
+	writeJSON(w, http.StatusBadRequest, bodyProblems{Error: "invalid request body", Problems: problems})
+}
+
+// Check the fields of a JSON object against the struct T it is decoded into. Each field is decoded
+// on its own so all invalid values are reported, in strict mode fields T doesn't know are reported
+// as well. Returns the problems ordered by field name, missing required fields first.
+func checkBodyFields[T any](fields map[string]json.RawMessage, required []string, strict bool) []string {
+	var problems []string
+	for _, key := range required {
+		if _, ok := fields[key]; !ok {
+			problems = append(problems, fmt.Sprintf("missing field %q", key))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		single, _ := json.Marshal(map[string]json.RawMessage{key: fields[key]})
+		if err := json.Unmarshal(single, new(T)); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value of field %q", key))
+			continue
+		}
+		if strict {
+			decoder := json.NewDecoder(bytes.NewReader(single))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(new(T)); err != nil {
+				problems = append(problems, fmt.Sprintf("unknown field %q", key))
+			}
+		}
+	}
+	return problems
+}
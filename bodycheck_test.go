@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckBodyFields(t *testing.T) {
+	// Test that missing, invalid and, in strict mode, unknown fields are all reported
+	var fields map[string]json.RawMessage
+	json.Unmarshal([]byte(`{"lon": 8.6, "speed": "fast", "altitute": 120, "hdop": 5}`), &fields)
+	want := []string{`missing field "lat"`, `invalid value of field "speed"`}
+	if problems := checkBodyFields[locationPoint](fields, []string{"lat", "lon"}, false); !slices.Equal(problems, want) {
+		t.Fatalf("Unexpected problems: %q", problems)
+	}
+	want = []string{`missing field "lat"`, `unknown field "altitute"`, `invalid value of field "speed"`}
+	if problems := checkBodyFields[locationPoint](fields, []string{"lat", "lon"}, true); !slices.Equal(problems, want) {
+		t.Fatalf("Unexpected strict problems: %q", problems)
+	}
+}
+
+func TestTrackPostHandlerBodyProblems(t *testing.T) {
+	// Test that POST /track answers missing and unknown fields with a list of problems
+	a := setupTestApp(t)
+	defer a.db.Close()
+	post := func(body string) (int, bodyProblems) {
+		req := httptest.NewRequest(http.MethodPost, "/track?token=testtoken", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		a.trackPostHandler(rec, req)
+		var problems bodyProblems
+		if rec.Code == http.StatusBadRequest {
+			if err := json.Unmarshal(rec.Body.Bytes(), &problems); err != nil {
+				t.Fatalf("Expected problems as JSON, got %s", rec.Body.String())
+			}
+		}
+		return rec.Code, problems
+	}
+
+	status, problems := post(`{"lon": 8.6, "timestamp": "yesterday"}`)
+	want := []string{`missing field "lat"`, `invalid value of field "timestamp"`}
+	if status != http.StatusBadRequest || !slices.Equal(problems.Problems, want) {
+		t.Fatalf("Expected missing lat and invalid timestamp, got %d %+v", status, problems)
+	}
+
+	body := `{"lat": 50.1, "lon": 8.6, "timestamp": 1680000000, "altitute": 120}`
+	if status, _ := post(body); status != http.StatusOK {
+		t.Fatalf("Expected unknown fields to be ignored by default, got %d", status)
+	}
+	a.config.strictJSON = true
+	status, problems = post(body)
+	if status != http.StatusBadRequest || !slices.Equal(problems.Problems, []string{`unknown field "altitute"`}) {
+		t.Fatalf("Expected unknown field in strict mode, got %d %+v", status, problems)
+	}
+}
+
+func TestTrackPostHandlerServerFields(t *testing.T) {
+	// Test that fields set by the server can't be sent in the body, strict mode reports them as unknown
+	a := setupTestApp(t)
+	defer a.db.Close()
+	body := `{"lat": 50.1, "lon": 8.6, "timestamp": 1680000000, "hdop": 1, "low_quality": true, "bearing_derived": true}`
+	rec := httptest.NewRecorder()
+	a.trackPostHandler(rec, httptest.NewRequest(http.MethodPost, "/track?token=testtoken", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected server fields to be ignored by default, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var lowQuality, bearingDerived bool
+	if err := a.db.QueryRow("SELECT low_quality, bearing_derived FROM locations").Scan(&lowQuality, &bearingDerived); err != nil || lowQuality || bearingDerived {
+		t.Fatalf("Expected flags computed by the server, got %v %v (%v)", lowQuality, bearingDerived, err)
+	}
+
+	a.config.strictJSON = true
+	rec = httptest.NewRecorder()
+	a.trackPostHandler(rec, httptest.NewRequest(http.MethodPost, "/track?token=testtoken", strings.NewReader(body)))
+	var problems bodyProblems
+	json.Unmarshal(rec.Body.Bytes(), &problems)
+	if rec.Code != http.StatusBadRequest || !slices.Equal(problems.Problems, []string{`unknown field "bearing_derived"`, `unknown field "low_quality"`}) {
+		t.Fatalf("Expected server fields as unknown in strict mode, got %d %+v", rec.Code, problems)
+	}
+}
+
+func TestOwnTracksHandlerBodyProblems(t *testing.T) {
+	// Test that OwnTracks location messages are checked, with unknown fields only rejected in strict mode
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.devices = map[string]string{"phonetoken": "phone"}
+	post := func(body string) (int, bodyProblems) {
+		req := httptest.NewRequest(http.MethodPost, "/owntracks", strings.NewReader(body))
+		req.SetBasicAuth("user", "phonetoken")
+		rec := httptest.NewRecorder()
+		a.ownTracksHandler(rec, req)
+		var problems bodyProblems
+		if rec.Code == http.StatusBadRequest {
+			json.Unmarshal(rec.Body.Bytes(), &problems)
+		}
+		return rec.Code, problems
+	}
+
+	status, problems := post(`{"_type":"location","lat":50.1,"vel":"fast"}`)
+	want := []string{`missing field "lon"`, `missing field "tst"`, `invalid value of field "vel"`}
+	if status != http.StatusBadRequest || !slices.Equal(problems.Problems, want) {
+		t.Fatalf("Expected missing and invalid fields, got %d %+v", status, problems)
+	}
+
+	msg := `{"_type":"location","lat":50.1,"lon":8.6,"tst":1680000000,"tid":"ph","conn":"w"}`
+	if status, _ := post(msg); status != http.StatusOK {
+		t.Fatalf("Expected extra OwnTracks fields to be accepted, got %d", status)
+	}
+	a.config.ownTracksStrictJSON = true
+	status, problems = post(msg)
+	if status != http.StatusBadRequest || !slices.Equal(problems.Problems, []string{`unknown field "conn"`, `unknown field "tid"`}) {
+		t.Fatalf("Expected unknown fields in strict mode, got %d %+v", status, problems)
+	}
+}
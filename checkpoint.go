@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Copy the WAL into the main database file and truncate it, a no-op outside of WAL mode
+func (a *app) checkpointWAL() error {
+	if a.config.sqliteJournalMode != "WAL" {
+		return nil
+	}
+	// busy is 1 if the checkpoint couldn't complete because of other connections
+	var busy, walFrames, checkpointed int
+	if err := a.db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE);").Scan(&busy, &walFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		log.Printf("WAL checkpoint incomplete, database busy (%d of %d frames checkpointed)", checkpointed, walFrames)
+		return nil
+	}
+	log.Printf("WAL checkpoint finished (%d frames checkpointed)", checkpointed)
+	return nil
+}
+
+func (a *app) runCheckpoints() {
+	// Periodically checkpoint the WAL so it doesn't grow on long-running instances, until the hub shuts down
+	if a.config.walCheckpointInterval <= 0 || a.config.sqliteJournalMode != "WAL" {
+		return
+	}
+	ticker := time.NewTicker(a.config.walCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.hub.done:
+			return
+		case <-ticker.C:
+			if err := a.checkpointWAL(); err != nil {
+				log.Printf("Error checkpointing WAL: %v", err)
+			}
+		}
+	}
+}
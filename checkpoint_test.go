@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckpointWAL(t *testing.T) {
+	// Test that a checkpoint truncates the WAL file and is skipped outside of WAL mode
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for i := range 100 {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, int64(i), defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	walSize := func() int64 {
+		info, err := os.Stat(a.config.dbPath + "-wal")
+		if err != nil {
+			t.Fatalf("WAL file missing: %v", err)
+		}
+		return info.Size()
+	}
+	if walSize() == 0 {
+		t.Fatal("Expected a non-empty WAL file before the checkpoint")
+	}
+	if err := a.checkpointWAL(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if size := walSize(); size != 0 {
+		t.Fatalf("Expected truncated WAL file, got %d bytes", size)
+	}
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&count); err != nil || count != 100 {
+		t.Fatalf("Expected all rows after checkpoint: %v, %d", err, count)
+	}
+
+	a.config.sqliteJournalMode = "DELETE"
+	if err := a.checkpointWAL(); err != nil {
+		t.Fatalf("Expected no-op outside of WAL mode, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Connected WebSocket client as returned by the clients endpoint
+type clientInfo struct {
+	RemoteAddr  string `json:"remote_addr"`
+	ConnectedAt int64  `json:"connected_at"`
+	ClientID    string `json:"client_id,omitempty"`
+	// Subscribed devices, empty when the client receives all devices
+	Subscription []string `json:"subscription"`
+}
+
+// Response of the clients endpoint
+type clientsResponse struct {
+	Count   int          `json:"count"`
+	Clients []clientInfo `json:"clients"`
+}
+
+// Snapshot of the connected WebSocket clients, oldest connection first
+func (h *websocketHub) clientInfos() []clientInfo {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	states := slices.SortedFunc(maps.Values(h.clients), func(a, b clientState) int { return a.connectedAt.Compare(b.connectedAt) })
+	infos := make([]clientInfo, 0, len(states))
+	for _, state := range states {
+		subscription := slices.Sorted(maps.Keys(state.devices))
+		if subscription == nil {
+			subscription = []string{}
+		}
+		infos = append(infos, clientInfo{
+			RemoteAddr:   state.remoteAddr,
+			ConnectedAt:  state.connectedAt.UnixMilli(),
+			ClientID:     state.clientID,
+			Subscription: subscription,
+		})
+	}
+	return infos
+}
+
+func (a *app) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the connected WebSocket clients, e.g. to spot stale connections
+	clients := a.hub.clientInfos()
+	writeJSON(w, http.StatusOK, clientsResponse{Count: len(clients), Clients: clients})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestClientsHandler(t *testing.T) {
+	// Test that connected WebSocket clients are listed with address, connection time and subscription
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	get := func() clientsResponse {
+		rec := httptest.NewRecorder()
+		a.clientsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/clients", nil))
+		var resp clientsResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("Expected clients, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+	if resp := get(); resp.Count != 0 || resp.Clients == nil || len(resp.Clients) != 0 {
+		t.Fatalf("Expected an empty list, got %+v", resp)
+	}
+
+	start := time.Now().UnixMilli()
+	first, _, err := gwss.DefaultDialer.Dial(wsURL+"?client_id=tab1", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer first.Close()
+	expectMeta(t, first)
+	first.WriteJSON(map[string]any{"type": "subscribe", "devices": []string{"phone", "bike"}})
+	second, _, err := gwss.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	expectMeta(t, second)
+	time.Sleep(100 * time.Millisecond)
+
+	resp := get()
+	if resp.Count != 2 || len(resp.Clients) != 2 {
+		t.Fatalf("Expected 2 clients, got %+v", resp)
+	}
+	oldest, newest := resp.Clients[0], resp.Clients[1]
+	if oldest.ClientID != "tab1" || !slices.Equal(oldest.Subscription, []string{"bike", "phone"}) || oldest.RemoteAddr != "127.0.0.1" || oldest.ConnectedAt < start {
+		t.Fatalf("Unexpected first client: %+v", oldest)
+	}
+	if newest.ClientID != "" || newest.Subscription == nil || len(newest.Subscription) != 0 || newest.ConnectedAt < oldest.ConnectedAt {
+		t.Fatalf("Unexpected second client: %+v", newest)
+	}
+
+	second.Close()
+	time.Sleep(100 * time.Millisecond)
+	if resp := get(); resp.Count != 1 {
+		t.Fatalf("Expected the closed client to be gone, got %+v", resp)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Helper to parse a comma-separated list of allowed CORS origins
+func parseCORSOrigins(s string) []string {
+	var origins []string
+	for origin := range strings.SplitSeq(s, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// Check whether an origin is in the configured allowlist
+func (a *app) corsOriginAllowed(origin string) bool {
+	return slices.Contains(a.config.corsOrigins, "*") || slices.Contains(a.config.corsOrigins, origin)
+}
+
+// CORS middleware for browser-facing API handlers registered for method, also answers preflight
+// requests. Listed origins are echoed with credentials allowed, the wildcard allows any origin
+// without credentials, so no website can make requests with the user's basic authentication.
+func (a *app) cors(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && a.corsOriginAllowed(origin)
+		if len(a.config.corsOrigins) > 0 {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed {
+			if slices.Contains(a.config.corsOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if r.Method == http.MethodOptions {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler(w, r)
+	}
+}
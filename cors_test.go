@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	// Test that only allowed origins are echoed and preflight requests are answered
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.corsOrigins = parseCORSOrigins("https://map.example.com, https://other.example.com/")
+	called := false
+	h := a.cors(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	req.Header.Set("Origin", "https://map.example.com")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://map.example.com" || !called {
+		t.Fatalf("Expected allowed origin to be echoed, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Expected no CORS header for disallowed origin, got %q", got)
+	}
+
+	called = false
+	req = httptest.NewRequest(http.MethodOptions, "/api/history", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusNoContent || called || rec.Header().Get("Access-Control-Allow-Methods") != "GET, OPTIONS" {
+		t.Fatalf("Unexpected preflight response: %d, called=%v", rec.Code, called)
+	}
+
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("Expected credentials to be allowed for a listed origin")
+	}
+
+	// The wildcard is sent literally and never together with credentials
+	a.config.corsOrigins = parseCORSOrigins("*")
+	req = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("Expected wildcard without credentials, got %q", got)
+	}
+}
+
+func TestCORSPreflightMethods(t *testing.T) {
+	// Test that preflight requests allow the method the route is registered for
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.corsOrigins = parseCORSOrigins("https://map.example.com")
+	handler := a.routes()
+	for path, method := range map[string]string{"/api/token/rotate": "POST", "/api/locations": "DELETE", "/api/history": "GET"} {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://map.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != method+", OPTIONS" {
+			t.Fatalf("Unexpected preflight response for %s %s: %d %q", method, path, rec.Code, rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
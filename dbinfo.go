@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// Size and contents of the database, timestamps are nil when there are no locations
+type dbInfo struct {
+	Rows      int64  `json:"rows"`
+	Earliest  *int64 `json:"earliest"`
+	Latest    *int64 `json:"latest"`
+	SizeBytes int64  `json:"size_bytes"`
+	FreeBytes int64  `json:"free_bytes"`
+}
+
+func (a *app) dbInfoHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the number of stored locations, their time span and the size of the database file
+	ctx, cancel := a.dbContext(r.Context())
+	defer cancel()
+	db := a.reader()
+	var info dbInfo
+	// SQLite keeps no row count, COUNT(*) scans the smallest index and takes longer as the table
+	// grows, MIN and MAX are single lookups in the timestamp index
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM locations;").Scan(&info.Rows, &info.Earliest, &info.Latest); err != nil {
+		log.Printf("Error querying location count: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	// Pages on the freelist are part of the file until a VACUUM, the WAL file is not included
+	var pageCount, pageSize, freePages int64
+	err := db.QueryRowContext(ctx, "SELECT page_count, page_size, freelist_count FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count();").Scan(&pageCount, &pageSize, &freePages)
+	if err != nil {
+		log.Printf("Error querying database size: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	info.SizeBytes = pageCount * pageSize
+	info.FreeBytes = freePages * pageSize
+	writeJSON(w, http.StatusOK, info)
+}
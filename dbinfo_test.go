@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDBInfoHandler(t *testing.T) {
+	// Test that the row count, time span and database size are returned
+	a := setupTestApp(t)
+	defer a.db.Close()
+	get := func() dbInfo {
+		rec := httptest.NewRecorder()
+		a.dbInfoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dbinfo", nil))
+		var info dbInfo
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &info) != nil {
+			t.Fatalf("Expected database info, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return info
+	}
+
+	if info := get(); info.Rows != 0 || info.Earliest != nil || info.Latest != nil || info.SizeBytes <= 0 {
+		t.Fatalf("Unexpected info of an empty database: %+v", info)
+	}
+	for _, ts := range []int64{3000, 1000, 2000} {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, ts, "phone", false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	info := get()
+	if info.Rows != 3 || info.Earliest == nil || *info.Earliest != 1000 || info.Latest == nil || *info.Latest != 3000 {
+		t.Fatalf("Unexpected info: %+v", info)
+	}
+	if info.SizeBytes%4096 != 0 || info.FreeBytes < 0 || info.FreeBytes > info.SizeBytes {
+		t.Fatalf("Unexpected database size: %+v", info)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// Last stored point per device, used to skip stationary duplicates
+type dedupeTracker struct {
+	mutex sync.Mutex
+	last  map[string]locationPoint
+}
+
+// Lightweight message telling clients that a device is still at its last stored location
+type stillHereMessage struct {
+	DeviceID  string `json:"device_id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Check if a point is closer than the minimum distance to the last stored point of its device
+// and was received within the maximum interval after it
+func (a *app) isDuplicate(p locationPoint) bool {
+	if a.config.minDistanceMeters <= 0 {
+		return false
+	}
+	a.dedupe.mutex.Lock()
+	defer a.dedupe.mutex.Unlock()
+	prev, ok := a.dedupe.last[p.DeviceID]
+	if !ok || p.Timestamp < prev.Timestamp || p.Timestamp-prev.Timestamp >= a.config.dedupeMaxInterval.Milliseconds() {
+		return false
+	}
+	return haversine(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude) < a.config.minDistanceMeters
+}
+
+// Remember a stored point as the reference for later duplicates, older points don't replace newer ones
+func (a *app) rememberStored(p locationPoint) {
+	if a.config.minDistanceMeters <= 0 {
+		return
+	}
+	a.dedupe.mutex.Lock()
+	defer a.dedupe.mutex.Unlock()
+	if a.dedupe.last == nil {
+		a.dedupe.last = make(map[string]locationPoint)
+	}
+	if prev, ok := a.dedupe.last[p.DeviceID]; ok && p.Timestamp < prev.Timestamp {
+		return
+	}
+	a.dedupe.last[p.DeviceID] = p
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestDeduplicateLocations(t *testing.T) {
+	// Test that points just inside the distance and time thresholds are skipped and others are stored
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.minDistanceMeters = 10
+	a.config.dedupeMaxInterval = time.Minute
+
+	store := func(lat float64, ts int64, device string) {
+		t.Helper()
+		if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: lat, Longitude: 8, Timestamp: ts, DeviceID: device}); err != nil {
+			t.Fatalf("Storing location failed: %v", err)
+		}
+	}
+	count := func() int {
+		var n int
+		a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&n)
+		return n
+	}
+
+	// 0.0001 degrees of latitude are about 11.1 m
+	store(50, 1000, "phone")
+	store(50.00008, 2000, "phone")
+	if n := count(); n != 1 {
+		t.Fatalf("Expected point just inside the distance to be skipped, got %d rows", n)
+	}
+	store(50.0001, 3000, "phone")
+	if n := count(); n != 2 {
+		t.Fatalf("Expected point just outside the distance to be stored, got %d rows", n)
+	}
+	store(50.0001, 3000+59999, "phone")
+	if n := count(); n != 2 {
+		t.Fatalf("Expected point just inside the interval to be skipped, got %d rows", n)
+	}
+	store(50.0001, 3000+60000, "phone")
+	if n := count(); n != 3 {
+		t.Fatalf("Expected point just outside the interval to be stored, got %d rows", n)
+	}
+	store(50.0001, 3000, "bike")
+	if n := count(); n != 4 {
+		t.Fatalf("Expected other devices to be compared separately, got %d rows", n)
+	}
+
+	a.config.minDistanceMeters = 0
+	store(50.0001, 3000+60001, "phone")
+	if n := count(); n != 5 {
+		t.Fatalf("Expected every point to be stored when disabled, got %d rows", n)
+	}
+}
+
+func TestDeduplicateBroadcast(t *testing.T) {
+	// Test that skipped duplicates are announced with a still_here message instead of an update
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.minDistanceMeters = 10
+	a.config.dedupeMaxInterval = time.Minute
+	a.config.dedupeBroadcast = true
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer c.Close()
+	expectMeta(t, c)
+
+	a.storeLocation(context.Background(), locationPoint{Latitude: 50, Longitude: 8, Timestamp: 1000, DeviceID: "phone"})
+	a.storeLocation(context.Background(), locationPoint{Latitude: 50, Longitude: 8, Timestamp: 2000, DeviceID: "phone"})
+	var types []string
+	var stillHere stillHereMessage
+	for range 2 {
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := c.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		types = append(types, msg.Type)
+		if msg.Type == "still_here" {
+			json.Unmarshal(msg.Payload, &stillHere)
+		}
+	}
+	if types[0] != "update" || types[1] != "still_here" || stillHere.DeviceID != "phone" || stillHere.Timestamp != 2000 {
+		t.Fatalf("Unexpected messages %v, %+v", types, stillHere)
+	}
+}
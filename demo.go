@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+// Device ID of the synthetic locations generated in demo mode, kept apart from real devices
+const demoDeviceID = "livetracker-demo"
+
+// Center and radius of the loop driven in demo mode, around the Tiergarten in Berlin
+const (
+	demoCenterLat    = 52.5145
+	demoCenterLon    = 13.3501
+	demoRadiusMeters = 800
+)
+
+// Number of points of one lap of the demo loop
+const demoLapPoints = 120
+
+// Synthetic location of the demo device at the given step of the loop, driving counterclockwise
+// with a slowly rising and falling altitude
+func demoPoint(step int, interval time.Duration, now time.Time) locationPoint {
+	angle := 2 * math.Pi * float64(step%demoLapPoints) / demoLapPoints
+	latRad := demoCenterLat * math.Pi / 180
+	lat := demoCenterLat + demoRadiusMeters*math.Sin(angle)/earthRadiusMeters*180/math.Pi
+	lon := demoCenterLon + demoRadiusMeters*math.Cos(angle)/(earthRadiusMeters*math.Cos(latRad))*180/math.Pi
+	// Driving counterclockwise, the device heads north while east of the center and west while north of it
+	bearing := math.Mod(360-angle*180/math.Pi, 360)
+	speed := 2 * math.Pi * demoRadiusMeters / demoLapPoints / interval.Seconds()
+	altitude := 35 + 10*math.Sin(2*angle)
+	hdop := 1.0
+	return locationPoint{
+		Latitude:  lat,
+		Longitude: lon,
+		Altitude:  &altitude,
+		Speed:     &speed,
+		Bearing:   &bearing,
+		Accuracy:  &hdop,
+		Timestamp: now.UnixMilli(),
+		DeviceID:  demoDeviceID,
+	}
+}
+
+func (a *app) runDemo(done <-chan struct{}) {
+	// Feed synthetic locations of the demo device through the normal insert and broadcast path until shutdown
+	ticker := time.NewTicker(a.config.demoInterval)
+	defer ticker.Stop()
+	for step := 0; ; step++ {
+		if _, err := a.storeLocation(context.Background(), demoPoint(step, a.config.demoInterval, time.Now())); err != nil {
+			log.Printf("Error storing demo location: %v", err)
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
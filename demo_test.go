@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDemoPoint(t *testing.T) {
+	// Test that demo points follow a closed loop of the configured radius with matching bearing and speed
+	now := time.UnixMilli(1700000000000)
+	first := demoPoint(0, 2*time.Second, now)
+	if first.DeviceID != demoDeviceID || first.Timestamp != now.UnixMilli() {
+		t.Fatalf("Unexpected demo point: %+v", first)
+	}
+	for step := range demoLapPoints {
+		p := demoPoint(step, 2*time.Second, now)
+		if d := haversine(demoCenterLat, demoCenterLon, p.Latitude, p.Longitude); math.Abs(d-demoRadiusMeters) > 1 {
+			t.Fatalf("Expected point %d on the loop, %f m from the center", step, d)
+		}
+		next := demoPoint(step+1, 2*time.Second, now)
+		if diff := math.Abs(math.Mod(initialBearing(p.Latitude, p.Longitude, next.Latitude, next.Longitude)-*p.Bearing+540, 360) - 180); diff > 2 {
+			t.Fatalf("Expected bearing of point %d to point to the next one, off by %f°", step, diff)
+		}
+		if d := haversine(p.Latitude, p.Longitude, next.Latitude, next.Longitude); math.Abs(d/2-*p.Speed) > 0.1 {
+			t.Fatalf("Expected speed of point %d to match the distance to the next one, got %f m/s for %f m", step, *p.Speed, d)
+		}
+	}
+	if last := demoPoint(demoLapPoints, 2*time.Second, now); last.Latitude != first.Latitude || last.Longitude != first.Longitude {
+		t.Fatalf("Expected the loop to close, got %+v", last)
+	}
+}
+
+func TestRunDemo(t *testing.T) {
+	// Test that demo mode stores and broadcasts locations of the demo device
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.demoInterval = 20 * time.Millisecond
+	done := make(chan struct{})
+	defer close(done)
+	go a.runDemo(done)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		p, err := a.queryLastLocation(context.Background(), demoDeviceID)
+		if err == nil && p.DeviceID == demoDeviceID {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("Expected a stored demo location")
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Helper to parse optional from/to Unix millisecond bounds from a query
+func parseTimeRange(query url.Values) (from, to *int64, err error) {
+	parse := func(key string) (*int64, error) {
+		s := query.Get(key)
+		if s == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", key)
+		}
+		return &v, nil
+	}
+	if from, err = parse("from"); err != nil {
+		return nil, nil, err
+	}
+	if to, err = parse("to"); err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+// Helper to build a WHERE clause and arguments for an optional time range
+func timeRangeClause(from, to *int64) (string, []any) {
+	var conditions []string
+	var args []any
+	if from != nil {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, *from)
+	}
+	if to != nil {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, *to)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Helper to convert a stored millisecond timestamp to time.Time
+func timestampToTime(ts int64) time.Time {
+	return time.UnixMilli(ts).UTC()
+}
+
+// Helper to set ETag and Last-Modified of an export from the row count and the newest timestamp and receive time
+// in range, the ETag also covers the format and query options of the export. Returns true after answering
+// a matching conditional request with 304 Not Modified
+func (a *app) exportNotModified(w http.ResponseWriter, r *http.Request, where string, args []any) bool {
+	ctx, cancel := a.dbContext(r.Context())
+	defer cancel()
+	var count int64
+	var maxTimestamp, lastReceived sql.NullInt64
+	err := a.reader().QueryRowContext(ctx, "SELECT COUNT(*), MAX(timestamp), CAST(strftime('%s', MAX(received_at)) AS INTEGER) FROM locations"+where, args...).
+		Scan(&count, &maxTimestamp, &lastReceived)
+	if err != nil {
+		// The export itself reports database errors
+		log.Printf("Error querying export validators: %v", err)
+		return false
+	}
+	// The same rows are exported differently per format, units, interval and so on, Encode sorts the parameters
+	variant := sha256.Sum256([]byte(r.URL.Path + "?" + r.URL.Query().Encode()))
+	// Weak because the gzip middleware may encode the same export differently
+	etag := fmt.Sprintf(`W/"%d-%d-%d-%x"`, count, maxTimestamp.Int64, lastReceived.Int64, variant[:8])
+	w.Header().Set("ETag", etag)
+	var modified time.Time
+	if lastReceived.Valid {
+		modified = time.Unix(lastReceived.Int64, 0).UTC()
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.IsZero() || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Helper to compare an If-None-Match header against an ETag using the weak comparison
+func etagMatches(header, etag string) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *app) exportGPXHandler(w http.ResponseWriter, r *http.Request) {
+	// Stream locations in the requested range as a GPX 1.1 document
+	from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minInterval, err := parseMinInterval(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := timeRangeClause(from, to)
+	if a.exportNotModified(w, r, where, args) {
+		return
+	}
+	clearDeadlines(w)
+	rows, err := a.reader().QueryContext(r.Context(), "SELECT "+locationColumns+" FROM locations"+where+" ORDER BY device_id ASC, timestamp ASC", args...)
+	if err != nil {
+		log.Printf("Error querying locations for GPX export: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="livetracker.gpx"`)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<gpx version="1.1" creator="`)
+	xml.EscapeText(bw, []byte(a.config.appName))
+	bw.WriteString(`" xmlns="http://www.topografix.com/GPX/1/1">` + "\n")
+
+	// Each device gets its own track, rows are ordered by device
+	currentDevice, started := "", false
+	thinner := newIntervalThinner(minInterval)
+	for rows.Next() {
+		p, err := scanLocation(rows)
+		if err != nil {
+			log.Printf("Error scanning GPX export row: %v", err)
+			continue
+		}
+		if !thinner.keep(p) {
+			continue
+		}
+		if !started || p.DeviceID != currentDevice {
+			if started {
+				bw.WriteString("</trkseg></trk>\n")
+			}
+			bw.WriteString("<trk><name>")
+			xml.EscapeText(bw, []byte(p.DeviceID))
+			bw.WriteString("</name><trkseg>\n")
+			currentDevice, started = p.DeviceID, true
+		}
+		fmt.Fprintf(bw, `<trkpt lat="%s" lon="%s">`, formatCoord(p.Latitude), formatCoord(p.Longitude))
+		if p.Altitude != nil {
+			fmt.Fprintf(bw, "<ele>%s</ele>", formatCoord(*p.Altitude))
+		}
+		fmt.Fprintf(bw, "<time>%s</time></trkpt>\n", timestampToTime(p.Timestamp).Format(time.RFC3339Nano))
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating GPX export rows: %v", err)
+	}
+	if !started {
+		bw.WriteString("<trk><trkseg>\n")
+	}
+	bw.WriteString("</trkseg></trk>\n</gpx>\n")
+}
+
+// Helper to format a KML coordinate tuple, the altitude is left out when unknown
+func kmlCoordinates(p locationPoint) string {
+	coords := formatCoord(p.Longitude) + "," + formatCoord(p.Latitude)
+	if p.Altitude != nil {
+		coords += "," + formatCoord(*p.Altitude)
+	}
+	return coords
+}
+
+// Helper to close the track of a device and mark its last point
+func writeKMLTrackEnd(bw *bufio.Writer, last locationPoint) {
+	bw.WriteString("</coordinates></LineString></Placemark>\n<Placemark><name>")
+	xml.EscapeText(bw, []byte(last.DeviceID))
+	fmt.Fprintf(bw, "</name><TimeStamp><when>%s</when></TimeStamp><Point><altitudeMode>absolute</altitudeMode><coordinates>%s</coordinates></Point></Placemark>\n",
+		timestampToTime(last.Timestamp).Format(time.RFC3339Nano), kmlCoordinates(last))
+}
+
+func (a *app) exportKMLHandler(w http.ResponseWriter, r *http.Request) {
+	// Stream locations in the requested range as a KML document with a line and the last point per device
+	from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minInterval, err := parseMinInterval(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := timeRangeClause(from, to)
+	if a.exportNotModified(w, r, where, args) {
+		return
+	}
+	clearDeadlines(w)
+	rows, err := a.reader().QueryContext(r.Context(), "SELECT "+locationColumns+" FROM locations"+where+" ORDER BY device_id ASC, timestamp ASC", args...)
+	if err != nil {
+		log.Printf("Error querying locations for KML export: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="livetracker.kml"`)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>`)
+	xml.EscapeText(bw, []byte(a.config.appName))
+	bw.WriteString("</name>\n")
+
+	// Each device gets its own line, rows are ordered by device
+	var last locationPoint
+	started := false
+	thinner := newIntervalThinner(minInterval)
+	for rows.Next() {
+		p, err := scanLocation(rows)
+		if err != nil {
+			log.Printf("Error scanning KML export row: %v", err)
+			continue
+		}
+		if !thinner.keep(p) {
+			continue
+		}
+		if !started || p.DeviceID != last.DeviceID {
+			if started {
+				writeKMLTrackEnd(bw, last)
+			}
+			bw.WriteString("<Placemark><name>")
+			xml.EscapeText(bw, []byte(p.DeviceID))
+			bw.WriteString("</name><LineString><altitudeMode>absolute</altitudeMode><coordinates>\n")
+			started = true
+		}
+		bw.WriteString(kmlCoordinates(p) + "\n")
+		last = p
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating KML export rows: %v", err)
+	}
+	if started {
+		writeKMLTrackEnd(bw, last)
+	}
+	bw.WriteString("</Document></kml>\n")
+}
+
+// Helper to format a coordinate without exponent notation
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// GeoJSON types used by the GeoJSON export
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+func (a *app) exportGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	// Return locations in the requested range as a GeoJSON FeatureCollection
+	from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minInterval, err := parseMinInterval(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	units, err := parseUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := timeRangeClause(from, to)
+	if a.exportNotModified(w, r, where, args) {
+		return
+	}
+	clearDeadlines(w)
+	rows, err := a.reader().QueryContext(r.Context(), "SELECT "+locationColumns+" FROM locations"+where+" ORDER BY timestamp ASC", args...)
+	if err != nil {
+		log.Printf("Error querying locations for GeoJSON export: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// GeoJSON coordinates are in [lon, lat] order
+	lineCoords := [][]float64{}
+	pointFeatures := []geoJSONFeature{}
+	thinner := newIntervalThinner(minInterval)
+	for rows.Next() {
+		p, err := scanLocation(rows)
+		if err != nil {
+			log.Printf("Error scanning GeoJSON export row: %v", err)
+			continue
+		}
+		if !thinner.keep(p) {
+			continue
+		}
+		p = units.convert(p)
+		coord := []float64{p.Longitude, p.Latitude}
+		lineCoords = append(lineCoords, coord)
+		pointFeatures = append(pointFeatures, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: coord},
+			Properties: map[string]any{
+				"timestamp": p.Timestamp,
+				"device_id": p.DeviceID,
+				"altitude":  p.Altitude,
+				"speed":     p.Speed,
+				"bearing":   p.Bearing,
+				"hdop":      p.Accuracy,
+			},
+		})
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating GeoJSON export rows: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	collection := geoJSONFeatureCollection{
+		Type: "FeatureCollection",
+		Features: append([]geoJSONFeature{{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: lineCoords},
+			Properties: map[string]any{},
+		}}, pointFeatures...),
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="livetracker.geojson"`)
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		log.Printf("Error encoding GeoJSON export: %v", err)
+	}
+}
+
+// Helper to format an optional float for CSV, nil becomes an empty cell
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return formatCoord(*f)
+}
+
+func (a *app) exportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	// Stream locations in the requested range as CSV, optionally with an RFC3339 time column
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minInterval, err := parseMinInterval(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withTime, _ := strconv.ParseBool(query.Get("rfc3339"))
+	units, err := parseUnits(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := timeRangeClause(from, to)
+	if a.exportNotModified(w, r, where, args) {
+		return
+	}
+	clearDeadlines(w)
+	rows, err := a.reader().QueryContext(r.Context(), "SELECT "+locationColumns+" FROM locations"+where+" ORDER BY timestamp ASC", args...)
+	if err != nil {
+		log.Printf("Error querying locations for CSV export: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="livetracker.csv"`)
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header := []string{"timestamp"}
+	if withTime {
+		header = append(header, "time")
+	}
+	cw.Write(append(header, "lat", "lon", "altitude", "speed", "bearing", "accuracy", "device_id"))
+	thinner := newIntervalThinner(minInterval)
+	for rows.Next() {
+		p, err := scanLocation(rows)
+		if err != nil {
+			log.Printf("Error scanning CSV export row: %v", err)
+			continue
+		}
+		if !thinner.keep(p) {
+			continue
+		}
+		p = units.convert(p)
+		record := []string{strconv.FormatInt(p.Timestamp, 10)}
+		if withTime {
+			record = append(record, timestampToTime(p.Timestamp).Format(time.RFC3339Nano))
+		}
+		record = append(record,
+			formatCoord(p.Latitude),
+			formatCoord(p.Longitude),
+			formatOptionalFloat(p.Altitude),
+			formatOptionalFloat(p.Speed),
+			formatOptionalFloat(p.Bearing),
+			formatOptionalFloat(p.Accuracy),
+			p.DeviceID,
+		)
+		if err := cw.Write(record); err != nil {
+			log.Printf("Error writing CSV export: %v", err)
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating CSV export rows: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportGPXHandler(t *testing.T) {
+	// Test that /export/gpx returns a valid GPX document for the requested range
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for _, ts := range []int64{1000, 2000, 3000} {
+		if _, err := a.insertLocationStmt.Exec(50.1, 8.6, 100.5, nil, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(a.exportGPXHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/export/gpx?from=1500&to=3000")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/gpx+xml" {
+		t.Fatalf("Unexpected Content-Type: %s", ct)
+	}
+	var doc struct {
+		Tracks []struct {
+			Points []struct {
+				Lat  float64 `xml:"lat,attr"`
+				Ele  float64 `xml:"ele"`
+				Time string  `xml:"time"`
+			} `xml:"trkseg>trkpt"`
+		} `xml:"trk"`
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Invalid GPX: %v\n%s", err, body)
+	}
+	if len(doc.Tracks) != 1 || len(doc.Tracks[0].Points) != 2 {
+		t.Fatalf("Expected one track with 2 points, got %+v", doc.Tracks)
+	}
+	if p := doc.Tracks[0].Points[0]; p.Lat != 50.1 || p.Ele != 100.5 || p.Time != "1970-01-01T00:00:02Z" {
+		t.Fatalf("Unexpected point: %+v", p)
+	}
+
+	// Empty result must still be well-formed
+	resp, err = http.Get(srv.URL + "/export/gpx?from=999999")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Invalid empty GPX: %v\n%s", err, body)
+	}
+
+	resp, _ = http.Get(srv.URL + "/export/gpx?from=abc")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestExportGeoJSONHandler(t *testing.T) {
+	// Test that /export/geojson returns a LineString and Point features in [lon, lat] order
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for _, ts := range []int64{1000, 2000} {
+		if _, err := a.insertLocationStmt.Exec(50.1, 8.6, nil, 3.5, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(a.exportGeoJSONHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/export/geojson?to=1500")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/geo+json" {
+		t.Fatalf("Unexpected Content-Type: %s", ct)
+	}
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fc); err != nil {
+		t.Fatalf("Invalid GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+		t.Fatalf("Unexpected collection: %+v", fc)
+	}
+	if fc.Features[0].Geometry.Type != "LineString" || string(fc.Features[0].Geometry.Coordinates) != "[[8.6,50.1]]" {
+		t.Fatalf("Unexpected LineString: %s", fc.Features[0].Geometry.Coordinates)
+	}
+	if fc.Features[1].Geometry.Type != "Point" || fc.Features[1].Properties["speed"] != 3.5 {
+		t.Fatalf("Unexpected Point feature: %+v", fc.Features[1])
+	}
+}
+
+func TestExportCSVHandler(t *testing.T) {
+	// Test that /export/csv writes a header and rows with empty cells for null values
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.insertLocationStmt.Exec(50.1, 8.6, 100.5, nil, 90.0, nil, 1680000000000, defaultDeviceID, false, nil, nil, false, nil)
+	srv := httptest.NewServer(http.HandlerFunc(a.exportCSVHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/export/csv")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expected := "timestamp,lat,lon,altitude,speed,bearing,accuracy,device_id\n1680000000000,50.1,8.6,100.5,,90,,default\n"
+	if string(body) != expected {
+		t.Fatalf("Unexpected CSV:\n%s", body)
+	}
+
+	resp, err = http.Get(srv.URL + "/export/csv?rfc3339=true")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "timestamp,time,lat") || !strings.Contains(string(body), "1680000000000,2023-03-28T10:40:00Z,50.1") {
+		t.Fatalf("Unexpected CSV with time column:\n%s", body)
+	}
+
+	a.insertLocationStmt.Exec(50.2, 8.7, nil, nil, nil, nil, 1680000010000, defaultDeviceID, false, nil, nil, false, nil)
+	a.insertLocationStmt.Exec(50.3, 8.8, nil, nil, nil, nil, 1680000030000, defaultDeviceID, false, nil, nil, false, nil)
+	resp, err = http.Get(srv.URL + "/export/csv?min_interval=30")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Count(string(body), "\n") != 3 || strings.Contains(string(body), "50.2") {
+		t.Fatalf("Expected points at least 30 seconds apart:\n%s", body)
+	}
+}
+
+func TestExportKMLHandler(t *testing.T) {
+	// Test that /export/kml returns a line per device with lon,lat,alt tuples and marks the last point
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for _, ts := range []int64{1000, 2000, 3000} {
+		if _, err := a.insertLocationStmt.Exec(50.1, 8.6, 100.5, nil, nil, nil, ts, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if _, err := a.insertLocationStmt.Exec(51.2, 9.7, nil, nil, nil, nil, 2500, "bike", false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(a.exportKMLHandler))
+	defer srv.Close()
+
+	type placemark struct {
+		Name  string `xml:"name"`
+		When  string `xml:"TimeStamp>when"`
+		Line  string `xml:"LineString>coordinates"`
+		Point string `xml:"Point>coordinates"`
+	}
+	get := func(query string) []placemark {
+		resp, err := http.Get(srv.URL + "/export/kml?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/vnd.google-earth.kml+xml" {
+			t.Fatalf("Unexpected Content-Type: %s", ct)
+		}
+		var doc struct {
+			Placemarks []placemark `xml:"Document>Placemark"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if err := xml.Unmarshal(body, &doc); err != nil {
+			t.Fatalf("Invalid KML: %v\n%s", err, body)
+		}
+		return doc.Placemarks
+	}
+
+	placemarks := get("from=1500")
+	if len(placemarks) != 4 {
+		t.Fatalf("Expected a line and a last point per device, got %+v", placemarks)
+	}
+	if p := placemarks[0]; p.Name != "bike" || strings.TrimSpace(p.Line) != "9.7,51.2" {
+		t.Fatalf("Unexpected bike line: %+v", p)
+	}
+	if p := placemarks[2]; p.Name != defaultDeviceID || strings.Fields(p.Line)[0] != "8.6,50.1,100.5" || len(strings.Fields(p.Line)) != 2 {
+		t.Fatalf("Unexpected default line: %+v", p)
+	}
+	if p := placemarks[3]; p.Point != "8.6,50.1,100.5" || p.When != "1970-01-01T00:00:03Z" {
+		t.Fatalf("Unexpected last point: %+v", p)
+	}
+
+	// Empty result must still be well-formed
+	if placemarks := get("from=999999"); len(placemarks) != 0 {
+		t.Fatalf("Expected empty document, got %+v", placemarks)
+	}
+}
+
+func TestExportConditionalRequests(t *testing.T) {
+	// Test that exports answer matching If-None-Match and If-Modified-Since with 304 until the range changes
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.db.Exec("INSERT INTO locations (latitude, longitude, timestamp, received_at) VALUES (1, 1, 1000, '2024-01-01 12:00:00');")
+	srv := httptest.NewServer(a.gzip(a.exportGPXHandler))
+	defer srv.Close()
+
+	get := func(header, value string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/export/gpx?from=1&to=5000", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || resp.Header.Get("Last-Modified") != "Mon, 01 Jan 2024 12:00:00 GMT" {
+		t.Fatalf("Expected 200 with validators, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := get("If-None-Match", `"other", `+etag); resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+		t.Fatalf("Expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+	if resp := get("If-Modified-Since", "Mon, 01 Jan 2024 12:00:00 GMT"); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Expected 304 when not modified since, got %d", resp.StatusCode)
+	}
+	if resp := get("If-Modified-Since", "Mon, 01 Jan 2024 11:59:59 GMT"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 when modified since, got %d", resp.StatusCode)
+	}
+
+	// Other formats and options of the same rows get their own ETag
+	for _, export := range []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{a.exportCSVHandler, "/export/csv?from=1&to=5000"},
+		{a.exportGPXHandler, "/export/gpx?from=1&to=5000&min_interval=60"},
+		{a.exportGPXHandler, "/export/gpx?from=0&to=5000"},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, export.target, nil)
+		req.Header.Set("If-None-Match", etag)
+		export.handler(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Fatalf("Expected 200 with another ETag for %s, got %d %s", export.target, rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+
+	// A late point within the range changes the ETag
+	a.insertLocationStmt.Exec(2.0, 2.0, nil, nil, nil, nil, 500, defaultDeviceID, false, nil, nil, false, nil)
+	if resp := get("If-None-Match", etag); resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("Expected 200 with a new ETag after an insert, got %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
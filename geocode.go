@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Default timeout for a single reverse geocoding request, including waiting for the rate limit
+const geocodeTimeout = 5 * time.Second
+
+// Decimals coordinates are rounded to before geocoding, 3 decimals are about 100 m
+const geocodePrecision = 3
+
+// Maximum number of cached places, the cache is cleared when it is full
+const geocodeCacheSize = 1000
+
+// Returned when the geocoding service didn't answer in time
+var errGeocodeTimeout = errors.New("reverse geocoding timed out")
+
+// Place name and address components of a location
+type place struct {
+	DisplayName string            `json:"display_name"`
+	Address     map[string]string `json:"address"`
+}
+
+// Response of the place endpoint
+type placeResponse struct {
+	place
+	Point locationPoint `json:"point"`
+}
+
+// Client of a Nominatim-compatible reverse geocoding service with a cache of places by rounded coordinates
+// and a limit of one request per second, as required by the public Nominatim instance
+type geocoder struct {
+	url       string
+	userAgent string
+	timeout   time.Duration
+	client    *http.Client
+	limiter   *rate.Limiter
+	mutex     sync.Mutex
+	cache     map[string]place
+}
+
+func newGeocoder(endpoint, userAgent string) *geocoder {
+	return &geocoder{
+		url:       endpoint,
+		userAgent: userAgent,
+		timeout:   geocodeTimeout,
+		client:    &http.Client{},
+		limiter:   rate.NewLimiter(rate.Every(time.Second), 1),
+		cache:     make(map[string]place),
+	}
+}
+
+// Look up the place of a location, served from the cache when a nearby location was looked up before
+func (g *geocoder) reverse(ctx context.Context, lat, lon float64) (place, error) {
+	latStr := strconv.FormatFloat(lat, 'f', geocodePrecision, 64)
+	lonStr := strconv.FormatFloat(lon, 'f', geocodePrecision, 64)
+	key := latStr + "," + lonStr
+	g.mutex.Lock()
+	cached, ok := g.cache[key]
+	g.mutex.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	if err := g.limiter.Wait(ctx); err != nil {
+		return place{}, errGeocodeTimeout
+	}
+	u, err := url.Parse(g.url)
+	if err != nil {
+		return place{}, err
+	}
+	query := u.Query()
+	query.Set("format", "jsonv2")
+	query.Set("lat", latStr)
+	query.Set("lon", lonStr)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return place{}, err
+	}
+	req.Header.Set("User-Agent", g.userAgent)
+	resp, err := g.client.Do(req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return place{}, errGeocodeTimeout
+	}
+	if err != nil {
+		return place{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var result struct {
+		place
+		// Set instead of a place, e.g. for locations at sea
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return place{}, errGeocodeTimeout
+		}
+		return place{}, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if len(g.cache) >= geocodeCacheSize {
+		clear(g.cache)
+	}
+	// Locations without a place are cached too, so they aren't looked up again
+	g.cache[key] = result.place
+	return result.place, nil
+}
+
+func (a *app) placeHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the place of the most recent location, optionally of a single device, or 204 if there is none
+	if a.geocoder == nil {
+		http.Error(w, "Reverse geocoding is not enabled", http.StatusNotFound)
+		return
+	}
+	p, err := a.queryLastLocation(r.Context(), r.URL.Query().Get("device"))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching last location: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	pl, err := a.geocoder.reverse(r.Context(), p.Latitude, p.Longitude)
+	if errors.Is(err, errGeocodeTimeout) {
+		log.Printf("Reverse geocoding of the location of %s timed out", p.DeviceID)
+		http.Error(w, "Reverse geocoding timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reverse geocoding the location of %s: %v", p.DeviceID, err)
+		http.Error(w, "Reverse geocoding failed", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, placeResponse{place: pl, Point: p})
+}
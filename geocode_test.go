@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPlaceHandler(t *testing.T) {
+	// Test that the latest point is geocoded with rounded coordinates and nearby points are served from the cache
+	a := setupTestApp(t)
+	defer a.db.Close()
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		query := r.URL.Query()
+		if query.Get("lat") != "52.516" || query.Get("lon") != "13.378" || query.Get("format") != "jsonv2" || r.Header.Get("User-Agent") != "test" {
+			t.Errorf("Unexpected geocoding request %s (User-Agent %q)", r.URL, r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(`{"display_name":"Pariser Platz, Berlin","address":{"road":"Pariser Platz","city":"Berlin"}}`))
+	}))
+	defer upstream.Close()
+
+	rec := httptest.NewRecorder()
+	a.placeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/place", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 while disabled, got %d", rec.Code)
+	}
+	a.geocoder = newGeocoder(upstream.URL+"/reverse", "test")
+
+	rec = httptest.NewRecorder()
+	a.placeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/place", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 without locations, got %d", rec.Code)
+	}
+
+	for i, lat := range []float64{52.51631, 52.51629} {
+		if _, err := a.insertLocationStmt.Exec(lat, 13.37770, nil, nil, nil, nil, int64(1000+i), "phone", false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		rec = httptest.NewRecorder()
+		a.placeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/place?device=phone", nil))
+		var resp placeResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("Expected place, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if resp.DisplayName != "Pariser Platz, Berlin" || resp.Address["city"] != "Berlin" || resp.Point.Latitude != lat {
+			t.Fatalf("Unexpected place: %+v", resp)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("Expected 1 geocoding request, got %d", n)
+	}
+}
+
+func TestPlaceHandlerUpstreamErrors(t *testing.T) {
+	// Test that a slow or failing geocoding service results in 504 and 502
+	a := setupTestApp(t)
+	defer a.db.Close()
+	if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, int64(1000), "phone", false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	a.geocoder = newGeocoder(slow.URL, "test")
+	a.geocoder.timeout = 100 * time.Millisecond
+	rec := httptest.NewRecorder()
+	a.placeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/place", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504 for a slow service, got %d", rec.Code)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bandwidth limit exceeded", http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+	a.geocoder = newGeocoder(failing.URL, "test")
+	rec = httptest.NewRecorder()
+	a.placeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/place", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502 for a failing service, got %d", rec.Code)
+	}
+	if _, err := a.geocoder.reverse(context.Background(), 1, 2); err == nil {
+		t.Fatal("Expected failed lookups not to be cached")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout for delivering a webhook request
+const webhookTimeout = 10 * time.Second
+
+// Capacity of the webhook queue, events are dropped when the endpoint can't keep up
+const webhookQueueSize = 64
+
+// Circular area that triggers events when a device enters or leaves it
+type geofence struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Radius    float64 `json:"radius"`
+}
+
+// Check whether a point lies within the geofence
+func (g geofence) contains(lat, lon float64) bool {
+	return haversine(g.Latitude, g.Longitude, lat, lon) <= g.Radius
+}
+
+// Event posted to the webhook URL on geofence transitions
+type geofenceEvent struct {
+	Geofence   string        `json:"geofence"`
+	Transition string        `json:"transition"`
+	Point      locationPoint `json:"point"`
+}
+
+// Last known point per device, used to detect geofence transitions
+type geofenceTracker struct {
+	mutex sync.Mutex
+	last  map[string]locationPoint
+}
+
+// Helper to parse geofences in the form "name:lat:lon:radius,..."
+func parseGeofences(s string) ([]geofence, error) {
+	var fences []geofence
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid geofence %q, expected name:lat:lon:radius", entry)
+		}
+		var values [3]float64
+		for i, part := range parts[1:] {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q in geofence %q", part, entry)
+			}
+			values[i] = v
+		}
+		if values[2] <= 0 {
+			return nil, fmt.Errorf("radius of geofence %q must be positive", entry)
+		}
+		fences = append(fences, geofence{Name: strings.TrimSpace(parts[0]), Latitude: values[0], Longitude: values[1], Radius: values[2]})
+	}
+	return fences, nil
+}
+
+// Compare a new point with the previous point of its device and return geofence transitions
+func (a *app) geofenceTransitions(p locationPoint) []geofenceEvent {
+	if len(a.config.geofences) == 0 {
+		return nil
+	}
+	a.geofenceState.mutex.Lock()
+	if a.geofenceState.last == nil {
+		a.geofenceState.last = make(map[string]locationPoint)
+	}
+	prev, ok := a.geofenceState.last[p.DeviceID]
+	if ok && p.Timestamp < prev.Timestamp {
+		// Ignore points arriving out of order
+		a.geofenceState.mutex.Unlock()
+		return nil
+	}
+	a.geofenceState.last[p.DeviceID] = p
+	a.geofenceState.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var events []geofenceEvent
+	for _, g := range a.config.geofences {
+		wasInside := g.contains(prev.Latitude, prev.Longitude)
+		isInside := g.contains(p.Latitude, p.Longitude)
+		switch {
+		case !wasInside && isInside:
+			events = append(events, geofenceEvent{Geofence: g.Name, Transition: "enter", Point: p})
+		case wasInside && !isInside:
+			events = append(events, geofenceEvent{Geofence: g.Name, Transition: "exit", Point: p})
+		}
+	}
+	return events
+}
+
+// Detect geofence transitions for a point and queue them for the webhook
+func (a *app) checkGeofences(p locationPoint) {
+	for _, event := range a.geofenceTransitions(p) {
+		log.Printf("Device %s: %s geofence %s", p.DeviceID, event.Transition, event.Geofence)
+		a.webhook.publish(event)
+	}
+}
+
+// Posts geofence events to the webhook URL one at a time with a shared client, so a chatty device or a slow
+// endpoint can neither block tracking requests nor pile up goroutines and connections
+type webhookSender struct {
+	url    string
+	client *http.Client
+	events chan geofenceEvent
+	done   chan struct{}
+	// Held for reading while an event is queued, so close never closes the channel under a sender
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func newWebhookSender(url string) *webhookSender {
+	return &webhookSender{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		events: make(chan geofenceEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Queue an event for the webhook, a no-op when no webhook is configured or the sender is closed
+func (s *webhookSender) publish(event geofenceEvent) {
+	if s == nil {
+		return
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		log.Printf("Webhook queue full, dropping %s event of geofence %s", event.Transition, event.Geofence)
+	}
+}
+
+func (s *webhookSender) run() {
+	// Deliver queued events until the sender is closed
+	defer close(s.done)
+	for event := range s.events {
+		s.send(event)
+	}
+}
+
+// Stop accepting events and wait until the queued ones are delivered, a no-op when no webhook is configured
+func (s *webhookSender) close() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mutex.Unlock()
+	<-s.done
+}
+
+// Post a geofence event to the webhook URL
+func (s *webhookSender) send(event geofenceEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshalling webhook event: %v", err)
+		return
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending webhook for geofence %s: %v", event.Geofence, err)
+		return
+	}
+	// Drain the body so the connection is reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("Webhook for geofence %s returned status %d", event.Geofence, resp.StatusCode)
+	}
+}
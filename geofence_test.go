@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseGeofences(t *testing.T) {
+	// Test that geofence definitions are parsed and validated
+	fences, err := parseGeofences("home:50.1:8.6:200, work:50.2:8.7:50.5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fences) != 2 || fences[0].Name != "home" || fences[1].Radius != 50.5 {
+		t.Fatalf("Unexpected geofences: %+v", fences)
+	}
+	for _, invalid := range []string{"home:50.1:8.6", "home:a:8.6:200", "home:50.1:8.6:0", ":1:2:3"} {
+		if _, err := parseGeofences(invalid); err == nil {
+			t.Fatalf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestGeofenceWebhook(t *testing.T) {
+	// Test that crossing a geofence boundary posts enter and exit events in order, all delivered before close returns
+	events := make(chan geofenceEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event geofenceEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+
+	a := &app{}
+	a.config.geofences = []geofence{{Name: "home", Latitude: 50, Longitude: 8, Radius: 100}}
+	a.webhook = newWebhookSender(hook.URL)
+	go a.webhook.run()
+
+	a.checkGeofences(locationPoint{Latitude: 50.01, Longitude: 8, Timestamp: 1, DeviceID: "phone"})
+	a.checkGeofences(locationPoint{Latitude: 50, Longitude: 8, Timestamp: 2, DeviceID: "phone"})
+	a.checkGeofences(locationPoint{Latitude: 50.0001, Longitude: 8, Timestamp: 3, DeviceID: "phone"})
+	a.checkGeofences(locationPoint{Latitude: 50.01, Longitude: 8, Timestamp: 4, DeviceID: "phone"})
+	// Another device starting outside must not produce an event
+	a.checkGeofences(locationPoint{Latitude: 50.01, Longitude: 8, Timestamp: 5, DeviceID: "bike"})
+
+	a.webhook.close()
+	close(events)
+	var transitions []string
+	for event := range events {
+		if event.Geofence != "home" || event.Point.DeviceID != "phone" {
+			t.Fatalf("Unexpected event: %+v", event)
+		}
+		transitions = append(transitions, event.Transition)
+	}
+	if !slices.Equal(transitions, []string{"enter", "exit"}) {
+		t.Fatalf("Expected enter and exit events, got %v", transitions)
+	}
+	// Events after close are discarded
+	a.checkGeofences(locationPoint{Latitude: 50, Longitude: 8, Timestamp: 6, DeviceID: "phone"})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	// Test parsing of Accept-Encoding headers
+	for header, expected := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"br, *":               true,
+		"gzip;q=0":            false,
+		"GZIP; q=0.0":         false,
+		"identity":            false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != expected {
+			t.Fatalf("For %q expected %v, got %v", header, expected, got)
+		}
+	}
+}
+
+func TestGzipExport(t *testing.T) {
+	// Test that streamed exports are complete when compressed and uncompressed otherwise
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.gzip = true
+	for i := range 2000 {
+		a.insertLocationStmt.Exec(50.1, 8.6, 100.5, nil, nil, nil, int64(1000+i), defaultDeviceID, false, nil, nil, false, nil)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(a.gzip(a.exportGPXHandler)))
+	defer srv.Close()
+
+	get := func(acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		// Setting the header disables the transparent decompression of the client
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Reading body failed: %v", err)
+		}
+		return resp, body
+	}
+
+	resp, compressed := get("gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Content-Type") != "application/gpx+xml" {
+		t.Fatalf("Unexpected headers: %v", resp.Header)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Invalid gzip stream: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Truncated gzip stream: %v", err)
+	}
+	var doc struct {
+		Points []struct{} `xml:"trk>trkseg>trkpt"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil || len(doc.Points) != 2000 {
+		t.Fatalf("Expected 2000 points in decompressed GPX: %v, %d", err, len(doc.Points))
+	}
+	if len(compressed) >= len(body) {
+		t.Fatalf("Expected compression, got %d bytes for %d", len(compressed), len(body))
+	}
+
+	resp, plain := get("identity")
+	if resp.Header.Get("Content-Encoding") != "" || string(plain) != string(body) {
+		t.Fatalf("Expected identical uncompressed response, got encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	a.config.gzip = false
+	srv.Config.Handler = http.HandlerFunc(a.gzip(a.exportGPXHandler))
+	if resp, _ := get("gzip"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("Expected no compression when disabled")
+	}
+}
+
+func TestGzipSkipsEncodedAndEmptyResponses(t *testing.T) {
+	// Test that responses with their own encoding or without a body pass through unchanged
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.gzip = true
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rec := httptest.NewRecorder()
+	a.gzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("already compressed"))
+	})(rec, req)
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "already compressed" {
+		t.Fatalf("Expected pass-through, got %q %q", rec.Header().Get("Content-Encoding"), rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	a.gzip(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Expected empty 204, got %d %q", rec.Code, rec.Body.String())
+	}
+}
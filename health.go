@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout for the database check of the health endpoint
+const healthCheckTimeout = 2 * time.Second
+
+// Outcome of the most recent location write, e.g. to notice a full disk
+type writeHealth struct {
+	mutex       sync.Mutex
+	failing     bool
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Record the result of a location write, a nil error marks writes as healthy again
+func (h *writeHealth) record(err error) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.failing = err != nil
+	if err != nil {
+		h.lastError, h.lastErrorAt = err.Error(), time.Now()
+	}
+}
+
+// Write status reported by the health endpoint, the last error is kept after writes recovered
+type writeStatus struct {
+	Status      string `json:"status"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+}
+
+func (h *writeHealth) status() writeStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	status := writeStatus{Status: "ok", LastError: h.lastError}
+	if h.failing {
+		status.Status = "failing"
+	}
+	if !h.lastErrorAt.IsZero() {
+		status.LastErrorAt = h.lastErrorAt.UnixMilli()
+	}
+	return status
+}
+
+func (a *app) healthHandler(w http.ResponseWriter, r *http.Request) {
+	// Report whether the server is up, the database responds and the last location write succeeded
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	var migrations int
+	err := a.db.PingContext(ctx)
+	if err == nil {
+		err = a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations;").Scan(&migrations)
+	}
+	if err != nil {
+		log.Printf("Health check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable"})
+		return
+	}
+	writes := a.writeHealth.status()
+	if writes.Status != "ok" {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "degraded", "migrations": migrations, "writes": writes})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "migrations": migrations, "writes": writes})
+}
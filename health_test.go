@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	// Test that /health reports ok while the DB is available and 503 afterwards
+	a := setupTestApp(t)
+	srv := httptest.NewServer(http.HandlerFunc(a.healthHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || body["status"] != "ok" || body["migrations"].(float64) != float64(len(migrations)) {
+		t.Fatalf("Unexpected health response: %d %v", resp.StatusCode, body)
+	}
+
+	a.db.Close()
+	resp, err = http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", resp.StatusCode)
+	}
+}
+
+func TestHealthHandlerWriteFailure(t *testing.T) {
+	// Test that a failed location write makes /health report degraded until the next successful write
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.healthHandler))
+	defer srv.Close()
+
+	get := func() (int, map[string]any) {
+		resp, err := http.Get(srv.URL + "/health")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	a.writeHealth.record(errors.New("database or disk is full"))
+	status, body := get()
+	writes, _ := body["writes"].(map[string]any)
+	if status != http.StatusServiceUnavailable || body["status"] != "degraded" || writes["status"] != "failing" || writes["last_error"] != "database or disk is full" {
+		t.Fatalf("Unexpected health response: %d %v", status, body)
+	}
+
+	a.writeHealth.record(nil)
+	status, body = get()
+	writes, _ = body["writes"].(map[string]any)
+	if status != http.StatusOK || body["status"] != "ok" || writes["status"] != "ok" || writes["last_error"] != "database or disk is full" {
+		t.Fatalf("Unexpected health response after recovery: %d %v", status, body)
+	}
+}
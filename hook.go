@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capacity of the point hook queue, points are dropped when the command can't keep up
+const pointHookQueueSize = 64
+
+// Maximum length of the command output included in the log of a failed run
+const pointHookMaxOutput = 512
+
+// Runs a command for every stored location point, one at a time, so a slow command can neither block
+// tracking requests nor pile up processes
+type pointHook struct {
+	command string
+	timeout time.Duration
+	points  chan locationPoint
+	done    chan struct{}
+	// Held for reading while a point is queued, so close never closes the channel under a sender
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func newPointHook(command string, timeout time.Duration) *pointHook {
+	return &pointHook{command: command, timeout: timeout, points: make(chan locationPoint, pointHookQueueSize), done: make(chan struct{})}
+}
+
+// Queue a point for the command, a no-op when no command is configured or the hook is closed
+func (h *pointHook) publish(p locationPoint) {
+	if h == nil {
+		return
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.points <- p:
+	default:
+		log.Printf("Point hook queue full, dropping location of %s", p.DeviceID)
+	}
+}
+
+func (h *pointHook) run() {
+	// Run the command for each queued point until the hook is closed
+	defer close(h.done)
+	for p := range h.points {
+		h.exec(p)
+	}
+}
+
+// Stop accepting points and wait until the queued ones are handled, a no-op when no command is configured
+func (h *pointHook) close() {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.points)
+	}
+	h.mutex.Unlock()
+	<-h.done
+}
+
+// Run the command through the shell with the point as JSON on stdin and its main fields as environment variables.
+// The command only gets PATH and HOME of the server's environment, so tokens and passwords configured there don't leak.
+func (h *pointHook) exec(p locationPoint) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error marshalling point hook location: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"LIVETRACKER_DEVICE_ID=" + p.DeviceID,
+		"LIVETRACKER_LAT=" + formatCoord(p.Latitude),
+		"LIVETRACKER_LON=" + formatCoord(p.Longitude),
+		"LIVETRACKER_TIMESTAMP=" + strconv.FormatInt(p.Timestamp, 10),
+	}
+	// Don't wait for background processes of the command that keep the output open
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		log.Printf("Point hook for %s timed out after %s", p.DeviceID, h.timeout)
+		return
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		log.Printf("Point hook for %s exited with code %d: %s", p.DeviceID, exitErr.ExitCode(), truncateOutput(output))
+		return
+	}
+	if err != nil {
+		log.Printf("Error running point hook for %s: %v", p.DeviceID, err)
+	}
+}
+
+// Helper to shorten command output for the log
+func truncateOutput(output []byte) string {
+	s := strings.TrimSpace(string(output))
+	if len(s) > pointHookMaxOutput {
+		return s[:pointHookMaxOutput] + "..."
+	}
+	return s
+}
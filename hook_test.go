@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPointHook(t *testing.T) {
+	// Test that the command receives the point as JSON on stdin and as environment variables, but no secrets
+	t.Setenv("LIVETRACKER_API_TOKEN", "secret")
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	h := newPointHook(`cat > "`+out+`.json" && echo "$LIVETRACKER_DEVICE_ID $LIVETRACKER_LAT $LIVETRACKER_LON $LIVETRACKER_TIMESTAMP$LIVETRACKER_API_TOKEN" > "`+out+`.env"`, 5*time.Second)
+	h.exec(locationPoint{Latitude: 52.5, Longitude: 13.25, Timestamp: 1000, DeviceID: "phone"})
+
+	env, err := os.ReadFile(out + ".env")
+	if err != nil || strings.TrimSpace(string(env)) != "phone 52.5 13.25 1000" {
+		t.Fatalf("Unexpected environment: %q (%v)", env, err)
+	}
+	data, err := os.ReadFile(out + ".json")
+	var p locationPoint
+	if err != nil || json.Unmarshal(data, &p) != nil || p.DeviceID != "phone" || p.Latitude != 52.5 {
+		t.Fatalf("Unexpected stdin: %s (%v)", data, err)
+	}
+}
+
+func TestPointHookFailures(t *testing.T) {
+	// Test that non-zero exit codes and timeouts are logged
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	newPointHook("echo broken >&2; exit 3", 5*time.Second).exec(locationPoint{DeviceID: "phone"})
+	if !strings.Contains(buf.String(), "exited with code 3: broken") {
+		t.Fatalf("Expected exit code in the log, got %q", buf.String())
+	}
+	buf.Reset()
+	start := time.Now()
+	newPointHook("sleep 5", 100*time.Millisecond).exec(locationPoint{DeviceID: "phone"})
+	if elapsed := time.Since(start); elapsed > 2*time.Second || !strings.Contains(buf.String(), "timed out") {
+		t.Fatalf("Expected timeout after %s in the log, got %q", elapsed, buf.String())
+	}
+}
+
+func TestPointHookQueue(t *testing.T) {
+	// Test that stored points are queued for the hook and points are dropped instead of blocking when it is full
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.pointHook = newPointHook("true", time.Second)
+	for i := range pointHookQueueSize + 5 {
+		if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: float64(i), Timestamp: int64(1000 + i), DeviceID: "phone"}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	if n := len(a.pointHook.points); n != pointHookQueueSize {
+		t.Fatalf("Expected a full queue of %d points, got %d", pointHookQueueSize, n)
+	}
+	var nilHook *pointHook
+	nilHook.publish(locationPoint{})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum accepted size of an uploaded GPX file
+const maxImportBytes = 50 << 20
+
+// Track point element of a GPX document
+type gpxTrackPoint struct {
+	Lat       string   `xml:"lat,attr"`
+	Lon       string   `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+}
+
+// Convert a GPX track point to a location point, fails for malformed points
+func (t gpxTrackPoint) toLocationPoint(deviceID string) (locationPoint, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(t.Lat), 64)
+	if err != nil {
+		return locationPoint{}, errors.New("invalid latitude")
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(t.Lon), 64)
+	if err != nil {
+		return locationPoint{}, errors.New("invalid longitude")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(t.Time))
+	if err != nil {
+		return locationPoint{}, errors.New("invalid or missing time")
+	}
+	return locationPoint{
+		Latitude:  lat,
+		Longitude: lon,
+		Timestamp: ts.UnixMilli(),
+		Altitude:  t.Elevation,
+		DeviceID:  deviceID,
+	}, nil
+}
+
+// Parse all track points of a GPX document, returns the valid points and the number of skipped ones
+func (a *app) parseGPX(r io.Reader, deviceID string) ([]locationPoint, int, error) {
+	var points []locationPoint
+	skipped := 0
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "trkpt" {
+			continue
+		}
+		var trkpt gpxTrackPoint
+		if err := decoder.DecodeElement(&trkpt, &start); err != nil {
+			// Element content like a non-numeric <ele> is a malformed point, not a broken document
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, 0, err
+			}
+			skipped++
+			continue
+		}
+		point, err := trkpt.toLocationPoint(deviceID)
+		if err == nil {
+			err = a.validateLocation(point)
+		}
+		if err != nil {
+			skipped++
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, skipped, nil
+}
+
+func (a *app) importGPXHandler(w http.ResponseWriter, r *http.Request) {
+	// Import track points from an uploaded GPX file, either as raw body or multipart "file" field
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		deviceID = defaultDeviceID
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing file field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	points, skipped, err := a.parseGPX(body, deviceID)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid GPX file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(points) > 0 {
+		err := insertLocationsTx(a.db, a.insertLocationStmt, points)
+		a.writeHealth.record(err)
+		if err != nil {
+			log.Printf("Error importing GPX: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		// Imported points aren't broadcast, older windows come from the database again
+		a.hub.recent.reset()
+	}
+	log.Printf("Imported %d points from GPX for device %s (%d skipped)", len(points), deviceID, skipped)
+	writeJSON(w, http.StatusOK, map[string]int{"imported": len(points), "skipped": skipped})
+}
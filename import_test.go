@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><trkseg>
+<trkpt lat="50.1" lon="8.6"><ele>100.5</ele><time>2023-03-28T10:40:00Z</time></trkpt>
+<trkpt lat="50.2" lon="8.7"><time>2023-03-28T10:41:00.500Z</time></trkpt>
+<trkpt lat="abc" lon="8.7"><time>2023-03-28T10:42:00Z</time></trkpt>
+<trkpt lat="50.3" lon="8.8"></trkpt>
+<trkpt lat="50.3" lon="8.8"><ele>high</ele><time>2023-03-28T10:43:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>`
+
+func TestImportGPXHandler(t *testing.T) {
+	// Test that valid track points are imported and malformed ones are skipped
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.importGPXHandler))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/import/gpx?device=hike", "application/gpx+xml", strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var result map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if result["imported"] != 2 || result["skipped"] != 3 {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	var ts int64
+	var ele float64
+	err = a.db.QueryRow("SELECT timestamp, altitude FROM locations WHERE device_id = 'hike' ORDER BY timestamp LIMIT 1;").Scan(&ts, &ele)
+	if err != nil || ts != 1680000000000 || ele != 100.5 {
+		t.Fatalf("Unexpected imported row: %v %d %v", err, ts, ele)
+	}
+
+	// Multipart uploads are supported as well
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "track.gpx")
+	fw.Write([]byte(testGPX))
+	mw.Close()
+	resp, err = http.Post(srv.URL+"/import/gpx", mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations WHERE device_id = ?;", defaultDeviceID).Scan(&count); err != nil || count != 2 {
+		t.Fatalf("Expected 2 rows from multipart import: %v, count=%d", err, count)
+	}
+
+	resp, _ = http.Post(srv.URL+"/import/gpx", "application/gpx+xml", strings.NewReader("<gpx><trk>"))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for broken document, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Default and maximum window of received locations the ingest lag is computed for
+const (
+	defaultLagWindowSeconds = 86400
+	maxLagWindowSeconds     = 30 * 86400
+)
+
+// Ingest lag statistics in milliseconds, nil when there are no locations in the window
+type lagStats struct {
+	WindowSeconds int64  `json:"window_seconds"`
+	Count         int    `json:"count"`
+	Median        *int64 `json:"median_ms"`
+	P95           *int64 `json:"p95_ms"`
+	Max           *int64 `json:"max_ms"`
+}
+
+// Helper to get the nearest-rank percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	index := max(int(math.Ceil(p*float64(len(sorted))))-1, 0)
+	return sorted[index]
+}
+
+func (a *app) lagHandler(w http.ResponseWriter, r *http.Request) {
+	// Return statistics of the difference between server receive time and device timestamp
+	query := r.URL.Query()
+	window := int64(defaultLagWindowSeconds)
+	if s := query.Get("window"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v <= 0 {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return
+		}
+		window = min(v, maxLagWindowSeconds)
+	}
+
+	// received_at has second resolution, the lag is therefore accurate to about a second
+	sqlQuery := `SELECT CAST(strftime('%s', received_at) AS INTEGER) * 1000 - timestamp FROM locations
+WHERE received_at >= datetime('now', ?)`
+	args := []any{"-" + strconv.FormatInt(window, 10) + " seconds"}
+	if device := query.Get("device"); device != "" {
+		sqlQuery += " AND device_id = ?"
+		args = append(args, device)
+	}
+	ctx, cancel := a.dbContext(r.Context())
+	defer cancel()
+	rows, err := a.reader().QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		log.Printf("Error querying ingest lag: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var lags []int64
+	for rows.Next() {
+		var lag int64
+		if err := rows.Scan(&lag); err != nil {
+			log.Printf("Error scanning ingest lag: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		lags = append(lags, lag)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating ingest lag: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := lagStats{WindowSeconds: window, Count: len(lags)}
+	if len(lags) > 0 {
+		slices.Sort(lags)
+		median, p95, maxLag := percentile(lags, 0.5), percentile(lags, 0.95), lags[len(lags)-1]
+		stats.Median, stats.P95, stats.Max = &median, &p95, &maxLag
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	// Test nearest-rank percentiles
+	values := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := percentile(values, 0.5); p != 5 {
+		t.Fatalf("Expected median 5, got %d", p)
+	}
+	if p := percentile(values, 0.95); p != 10 {
+		t.Fatalf("Expected p95 10, got %d", p)
+	}
+	if p := percentile([]int64{7}, 0.5); p != 7 {
+		t.Fatalf("Expected 7, got %d", p)
+	}
+}
+
+func TestLagHandler(t *testing.T) {
+	// Test that the ingest lag is computed for locations received within the window
+	a := setupTestApp(t)
+	defer a.db.Close()
+	srv := httptest.NewServer(http.HandlerFunc(a.lagHandler))
+	defer srv.Close()
+
+	get := func(query string) lagStats {
+		resp, err := http.Get(srv.URL + "/api/lag?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		var stats lagStats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return stats
+	}
+
+	if stats := get(""); stats.Count != 0 || stats.Median != nil {
+		t.Fatalf("Expected no data, got %+v", stats)
+	}
+
+	now := time.Now().UTC()
+	insert := func(receivedAt time.Time, lag time.Duration, device string) {
+		_, err := a.db.Exec("INSERT INTO locations(latitude, longitude, timestamp, received_at, device_id) VALUES(1, 2, ?, ?, ?)",
+			receivedAt.Add(-lag).Truncate(time.Second).UnixMilli(), receivedAt.Format("2006-01-02 15:04:05"), device)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	for i := range 19 {
+		insert(now, time.Duration(i+1)*time.Second, "phone")
+	}
+	insert(now, time.Hour, "bike")
+	// Outside of the default window
+	insert(now.Add(-48*time.Hour), 10*time.Hour, "phone")
+
+	stats := get("")
+	if stats.Count != 20 || *stats.Median != 10000 || *stats.P95 != 19000 || *stats.Max != 3600000 {
+		t.Fatalf("Unexpected stats: count %d, median %d, p95 %d, max %d", stats.Count, *stats.Median, *stats.P95, *stats.Max)
+	}
+	if stats := get("device=bike"); stats.Count != 1 || *stats.Median != 3600000 {
+		t.Fatalf("Unexpected bike stats: %+v", stats)
+	}
+	if stats := get("window=259200"); stats.Count != 21 || *stats.Max != 36000000 {
+		t.Fatalf("Unexpected stats for larger window: %+v", stats)
+	}
+
+	resp, _ := http.Get(srv.URL + "/api/lag?window=-1")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for invalid window, got %d", resp.StatusCode)
+	}
+}
+
+func TestReceivedAt(t *testing.T) {
+	// Test that stored and broadcast points carry the server receive time
+	a := setupTestApp(t)
+	defer a.db.Close()
+	before := time.Now().UnixMilli()
+	stored, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: 2, Timestamp: 1000, DeviceID: defaultDeviceID})
+	if err != nil {
+		t.Fatalf("Storing location failed: %v", err)
+	}
+	if stored.ReceivedAt == nil || *stored.ReceivedAt < before || *stored.ReceivedAt > time.Now().UnixMilli() {
+		t.Fatalf("Expected current receive time on the broadcast point, got %v", stored.ReceivedAt)
+	}
+
+	points, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil || len(points) != 1 {
+		t.Fatalf("Query failed: %v, %d points", err, len(points))
+	}
+	// The database default has second resolution
+	if r := points[0].ReceivedAt; r == nil || *r < before/1000*1000 || *r%1000 != 0 {
+		t.Fatalf("Unexpected stored receive time: %v", r)
+	}
+	data, _ := json.Marshal(locationPoint{Latitude: 1, Longitude: 2})
+	if string(data) != `{"lat":1,"lon":2,"timestamp":0,"device_id":""}` {
+		t.Fatalf("Expected received_at to be omitted when unknown, got %s", data)
+	}
+}
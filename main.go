@@ -2,38 +2,79 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io/fs"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/coder/websocket"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 //go:embed static
 var staticFiles embed.FS
 
-// Application name constant
-const appName = "LiveTracker"
+// Default application name, shown in the basic auth dialog, logs, exports and the page title
+const defaultAppName = "LiveTracker"
+
+// Maximum number of history windows in a single get_history message
+const maxHistoryWindows = 5
+
+// Capacity of the hub's broadcast queue, updates are dropped when it is full
+const broadcastBufferSize = 64
+
+// Device ID used for the single shared API token
+const defaultDeviceID = "default"
 
 // Main application struct holding config, DB, hub, and prepared statement
 type app struct {
-	config             appConfig
-	hub                *websocketHub
-	db                 *sql.DB
+	config appConfig
+	hub    *websocketHub
+	db     *sql.DB
+	// Read-only pool for history, export and stats queries, nil when reads share the writer pool
+	readDB             *sql.DB
 	insertLocationStmt *sql.Stmt
+	batch              *batchWriter
+	limiter            *ipRateLimiter
+	geofenceState      geofenceTracker
+	deviceStatus       deviceStatusTracker
+	bearingState       bearingTracker
+	tokenFile          tokenFile
+	apiToken           apiTokenState
+	dedupe             dedupeTracker
+	writeHealth        writeHealth
+	tripState          tripTracker
+	// Reverse geocoding client, disabled when nil
+	geocoder *geocoder
+	// Command run for every stored point, disabled when nil
+	pointHook *pointHook
+	// Delivery of geofence events to the webhook URL, disabled when nil
+	webhook *webhookSender
+	// Background loops that stop with the hub, waited for before the database is closed
+	background sync.WaitGroup
 }
 
 // Configuration for the application, loaded from environment variables
@@ -43,15 +84,309 @@ type appConfig struct {
 	token  string
 	user   string
 	pass   string
+	// URL path prefix all routes are served under, empty for the root
+	basePath string
+	// Address to bind to, all interfaces when empty
+	bindAddr string
+	// Optional token granting read-only access to the live view
+	shareToken string
+	// Name of the instance, e.g. to tell several instances apart
+	appName string
+	// Map of per-device API tokens to device IDs
+	devices map[string]string
+	// File with additional device tokens, reloaded on SIGHUP
+	tokensFile string
+	// File the API token is read from at startup and written to when rotated
+	apiTokenFile string
+	// Default and maximum history window sent to WebSocket clients
+	historySeconds    int64
+	maxHistorySeconds int64
+	// Maximum number of points per history message
+	historyChunkSize int64
+	// Minimum time between two points of a device that starts a new trip
+	tripGap time.Duration
+	// Altitude change in meters ignored as noise when computing ascent and descent
+	elevationThreshold float64
+	// Whether clients clear the track of a device when it starts a new trip
+	resetOnTrip bool
+	// Initial map view of the web interface
+	mapCenterLat float64
+	mapCenterLon float64
+	mapZoom      int64
+	// Tile server URL template and attribution of the map
+	tileURL         string
+	tileAttribution string
+	// Write-behind batching of inserts, disabled when batchSize <= 1
+	batchSize     int64
+	batchInterval time.Duration
+	// Interval for WebSocket keepalive pings, disabled when zero
+	wsPingInterval time.Duration
+	// Time without locations after which a device is reported offline, disabled when zero
+	onlineThreshold time.Duration
+	// Days of locations to keep (0 = forever) and whether to vacuum after pruning
+	retentionDays   int64
+	retentionVacuum bool
+	// Whether /metrics requires basic authentication
+	metricsAuth bool
+	// Whether the pprof handlers are served under /debug/pprof/
+	pprof bool
+	// Shell command run for every stored point and its timeout, disabled when empty
+	onPointCmd     string
+	onPointTimeout time.Duration
+	// MQTT broker to publish points to, disabled when mqttURL is empty
+	mqttURL      string
+	mqttTopic    string
+	mqttClientID string
+	mqttUser     string
+	mqttPass     string
+	mqttRetain   bool
+	// Whether every HTTP request is logged
+	accessLog bool
+	// Whether history and export responses are gzip-compressed for clients accepting it
+	gzip bool
+	// Allowed CORS origins for API endpoints, "*" allows any origin
+	corsOrigins []string
+	// TLS certificate and key files, HTTPS is enabled when both are set
+	tlsCert string
+	tlsKey  string
+	// HTTP server timeouts, each disabled when zero
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	// Maximum time for in-flight requests to finish on shutdown
+	shutdownTimeout time.Duration
+	// Whether unencrypted HTTP/2 (h2c) is accepted in addition to HTTP/1.1
+	h2c bool
+	// Per-IP rate limit for tracking requests (requests per second, 0 disables) and burst size
+	rateLimit float64
+	rateBurst int64
+	// Networks of reverse proxies whose forwarding headers are trusted
+	trustedProxies []*net.IPNet
+	// Networks whose clients may use the web interface without basic authentication
+	authSkipNetworks []*net.IPNet
+	// Maximum allowed difference of timestamps into the future, disabled when zero
+	maxFutureSkew time.Duration
+	// Query parameter of GET /track holding the label of a point, disabled when empty
+	labelParam string
+	// Maximum size of tracking request bodies and of the /track query string
+	maxTrackBodyBytes  int64
+	maxTrackQueryBytes int64
+	// Whether POST /track and /owntracks reject body fields they don't know
+	strictJSON          bool
+	ownTracksStrictJSON bool
+	// Timeout for writes to WebSocket clients
+	wsWriteTimeout time.Duration
+	// Interval for Server-Sent Events keepalive comments, disabled when zero
+	sseKeepaliveInterval time.Duration
+	// Whether to negotiate permessage-deflate compression with WebSocket clients
+	wsCompression bool
+	// Maximum number of concurrent WebSocket clients, unlimited when zero
+	maxWSClients int64
+	// Number of recent points kept in memory for history requests, disabled when zero
+	recentBuffer int64
+	// Time WebSocket subscriptions are remembered for reconnecting clients, disabled when zero
+	wsSessionTTL time.Duration
+	// Maximum random delay clients are told to wait before reconnecting after a shutdown, disabled when zero
+	reconnectJitter time.Duration
+	// SQLite connection tuning
+	sqliteBusyTimeout int64
+	sqliteJournalMode string
+	sqliteSynchronous string
+	// SQLite connection pool, unlimited open connections and lifetime when zero
+	sqliteMaxOpenConns    int64
+	sqliteMaxIdleConns    int64
+	sqliteConnMaxLifetime time.Duration
+	// SQLite memory map and page cache size per connection, SQLite's defaults when zero
+	sqliteMmapSizeMB  int64
+	sqliteCacheSizeMB int64
+	// Separate read-only connection pool for queries and its size, unlimited when zero
+	sqliteReadPool         bool
+	sqliteReadMaxOpenConns int64
+	// Interval of WAL checkpoints while running, disabled when zero
+	walCheckpointInterval time.Duration
+	// Timeout of database queries made for a request, disabled when zero
+	dbTimeout time.Duration
+	// Attempts and initial backoff for opening the database at startup
+	dbOpenAttempts     int64
+	dbOpenRetryBackoff time.Duration
+	// Attempts and initial backoff for inserts failing with busy or locked errors
+	insertAttempts     int64
+	insertRetryBackoff time.Duration
+	// Unit of the speed sent by devices, converted to m/s on insert
+	speedUnit string
+	// Whether to compute missing bearings from the previous point of a device
+	deriveBearing bool
+	// Skip points closer than minDistanceMeters to the last stored point of a device within dedupeMaxInterval,
+	// disabled when minDistanceMeters is zero
+	minDistanceMeters float64
+	dedupeMaxInterval time.Duration
+	// Whether skipped duplicates are announced to clients with a still_here message
+	dedupeBroadcast bool
+	// Maximum accepted HDOP/accuracy, disabled when zero, and whether worse fixes are dropped or stored flagged
+	maxHDOP        float64
+	lowQualityMode string
+	// Whether fixes without accuracy count as low-quality when maxHDOP is set
+	requireAccuracy bool
+	// Whether debug messages are logged
+	debug bool
+	// Geofences and the webhook URL notified on transitions
+	geofences  []geofence
+	webhookURL string
+	// Nominatim-compatible reverse geocoding endpoint, disabled when empty
+	geocodeURL string
+	// Directory the web interface is served from instead of the embedded files
+	staticDir string
+	// Generate synthetic locations of a demo device at this interval
+	demo         bool
+	demoInterval time.Duration
 }
 
 // WebSocket hub for managing clients and broadcasting messages
 type websocketHub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan locationPoint
-	register   chan *websocket.Conn
+	clients    map[*websocket.Conn]clientState
+	broadcast  chan hubMessage
+	register   chan clientRegistration
 	unregister chan *websocket.Conn
+	done       chan struct{}
 	mutex      sync.Mutex
+	// Maximum time a single write to a client may take
+	writeTimeout time.Duration
+	// Server-Sent Events clients receiving the same location updates
+	sse *sseRegistry
+	// Maximum number of connections, unlimited when zero, and the number of admitted connections
+	// including those not registered yet, guarded by mutex
+	maxClients int
+	slots      int
+	// Recently published points for serving short histories from memory, disabled when nil
+	recent *recentBuffer
+	// Publisher forwarding points to an MQTT broker, disabled when nil
+	mqtt *mqttPublisher
+	// Subscriptions remembered across reconnects, disabled when nil
+	sessions *sessionStore
+	// Maximum random reconnect delay sent to clients on shutdown, disabled when zero
+	reconnectJitter time.Duration
+}
+
+// Message broadcast by the hub to WebSocket clients
+type hubMessage struct {
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+	// Device the message belongs to, sent to all clients when empty
+	deviceID string
+	// Already marshalled message sent instead of type and payload, e.g. a control message
+	raw []byte
+}
+
+// Struct representing one chunk of historical data sent to a WebSocket client
+type historyMessage struct {
+	Type string `json:"type"`
+	// Label of the requested window, empty for a single window without label
+	Window  string          `json:"window,omitempty"`
+	Chunk   int             `json:"chunk"`
+	Last    bool            `json:"last"`
+	Payload []locationPoint `json:"payload"`
+}
+
+// Message telling a WebSocket client how long to wait before reconnecting after a shutdown
+type reconnectMessage struct {
+	Type    string `json:"type"`
+	AfterMs int64  `json:"afterMs"`
+}
+
+// Per-connection state of a WebSocket client
+type clientState struct {
+	// Devices the client subscribed to, all devices when empty
+	devices map[string]bool
+	// ID the client's subscription is remembered under, empty when the client sent none
+	clientID string
+	// Address and connection time, shown by the clients endpoint
+	remoteAddr  string
+	connectedAt time.Time
+}
+
+// Connection registered with the hub together with its initial state
+type clientRegistration struct {
+	conn  *websocket.Conn
+	state clientState
+}
+
+// Check whether a client wants to receive points of a device
+func (s clientState) wants(deviceID string) bool {
+	return len(s.devices) == 0 || s.devices[deviceID]
+}
+
+// Struct representing a message sent by a WebSocket client
+type wsClientMessage struct {
+	Type string `json:"type"`
+	historyWindow
+	Devices []string `json:"devices,omitempty"`
+	// Several history windows answered in one request instead of the single window above
+	Windows []historyWindow `json:"windows,omitempty"`
+	// Optional bounding box of requested history, used when all four values are set
+	MinLat *float64 `json:"min_lat,omitempty"`
+	MaxLat *float64 `json:"max_lat,omitempty"`
+	MinLon *float64 `json:"min_lon,omitempty"`
+	MaxLon *float64 `json:"max_lon,omitempty"`
+}
+
+// camelCase field names of WebSocket messages, accepted as aliases of the snake_case names
+var wsFieldAliases = map[string]string{
+	"maxPoints":      "max_points",
+	"minLat":         "min_lat",
+	"maxLat":         "max_lat",
+	"minLon":         "min_lon",
+	"maxLon":         "max_lon",
+	"minInterval":    "min_interval",
+	"sinceTimestamp": "since_timestamp",
+}
+
+// Helper to rename camelCase aliases of message fields, a field that is also set under its
+// snake_case name keeps that value
+func renameFieldAliases(fields map[string]json.RawMessage) {
+	for alias, name := range wsFieldAliases {
+		if value, ok := fields[alias]; ok {
+			if _, set := fields[name]; !set {
+				fields[name] = value
+			}
+			delete(fields, alias)
+		}
+	}
+}
+
+// Decode a client message accepting the camelCase aliases of its fields, also within windows
+func (m *wsClientMessage) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	renameFieldAliases(fields)
+	var windows []map[string]json.RawMessage
+	if raw, ok := fields["windows"]; ok && json.Unmarshal(raw, &windows) == nil {
+		for _, window := range windows {
+			renameFieldAliases(window)
+		}
+		fields["windows"], _ = json.Marshal(windows)
+	}
+	data, _ = json.Marshal(fields)
+	// Without its methods, so decoding doesn't recurse
+	type plainMessage wsClientMessage
+	return json.Unmarshal(data, (*plainMessage)(m))
+}
+
+// Time window and optional downsampling of requested history
+type historyWindow struct {
+	// Label the history chunks of the window are tagged with
+	Label   string          `json:"label,omitempty"`
+	Seconds json.RawMessage `json:"seconds,omitempty"`
+	// Timestamp of the newest point the client already has, only newer points are sent
+	SinceTimestamp int64 `json:"since_timestamp,omitempty"`
+	// Optional downsampling of requested history
+	MaxPoints  int     `json:"max_points,omitempty"`
+	Downsample string  `json:"downsample,omitempty"`
+	Epsilon    float64 `json:"epsilon,omitempty"`
+	// Minimum seconds between two points of a device
+	MinInterval float64 `json:"min_interval,omitempty"`
 }
 
 // Struct representing a single location point
@@ -63,12 +398,25 @@ type locationPoint struct {
 	Speed     *float64 `json:"speed,omitempty"`
 	Bearing   *float64 `json:"bearing,omitempty"`
 	Accuracy  *float64 `json:"hdop,omitempty"`
+	DeviceID  string   `json:"device_id"`
+	// Whether the bearing was computed from the previous point instead of reported by the device
+	BearingDerived bool `json:"bearing_derived,omitempty"`
+	// Optional battery level in percent and number of satellites reported by the device
+	Battery    *float64 `json:"battery,omitempty"`
+	Satellites *int64   `json:"satellites,omitempty"`
+	// Whether the accuracy of the fix is worse than the configured maximum HDOP
+	LowQuality bool `json:"low_quality,omitempty"`
+	// Optional label reported by the device, e.g. the activity type
+	Label *string `json:"label,omitempty"`
+	// Time the server received the point, Unix milliseconds with second resolution for stored points
+	ReceivedAt *int64 `json:"received_at,omitempty"`
 }
 
-// Database migration struct
+// Database migration struct, down reverts sql and is optional
 type migration struct {
-	id  string
-	sql string
+	id   string
+	sql  string
+	down string
 }
 
 // List of database migrations
@@ -93,51 +441,271 @@ CREATE TABLE IF NOT EXISTS locations (
 		id: "002_add_index",
 		sql: `
 CREATE INDEX IF NOT EXISTS idx_locations_timestamp ON locations (timestamp);
+`,
+		down: `
+DROP INDEX IF EXISTS idx_locations_timestamp;
+`,
+	},
+	{
+		id: "003_add_device_id",
+		sql: `
+ALTER TABLE locations ADD COLUMN device_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_locations_device_timestamp ON locations (device_id, timestamp);
+`,
+		down: `
+DROP INDEX IF EXISTS idx_locations_device_timestamp;
+ALTER TABLE locations DROP COLUMN device_id;
+`,
+	},
+	{
+		id: "004_add_bearing_derived",
+		sql: `
+ALTER TABLE locations ADD COLUMN bearing_derived INTEGER NOT NULL DEFAULT 0;
+`,
+		down: `
+ALTER TABLE locations DROP COLUMN bearing_derived;
+`,
+	},
+	{
+		id: "005_add_received_at_index",
+		sql: `
+CREATE INDEX IF NOT EXISTS idx_locations_received_at ON locations (received_at);
+`,
+		down: `
+DROP INDEX IF EXISTS idx_locations_received_at;
+`,
+	},
+	{
+		id: "006_add_battery_satellites",
+		sql: `
+ALTER TABLE locations ADD COLUMN battery REAL;
+ALTER TABLE locations ADD COLUMN satellites INTEGER;
+`,
+		down: `
+ALTER TABLE locations DROP COLUMN satellites;
+ALTER TABLE locations DROP COLUMN battery;
+`,
+	},
+	{
+		id: "007_add_lat_lon_index",
+		sql: `
+CREATE INDEX IF NOT EXISTS idx_locations_lat_lon ON locations(latitude, longitude);
+`,
+		down: `
+DROP INDEX IF EXISTS idx_locations_lat_lon;
+`,
+	},
+	{
+		id: "008_add_low_quality",
+		sql: `
+ALTER TABLE locations ADD COLUMN low_quality INTEGER NOT NULL DEFAULT 0;
+`,
+		down: `
+ALTER TABLE locations DROP COLUMN low_quality;
 `,
 	},
+	{
+		id: "009_add_label",
+		sql: `
+ALTER TABLE locations ADD COLUMN label TEXT;
+`,
+		down: `
+ALTER TABLE locations DROP COLUMN label;
+`,
+	},
+}
+
+func newWebsocketHub(writeTimeout time.Duration) *websocketHub {
+	return &websocketHub{
+		clients:      make(map[*websocket.Conn]clientState),
+		broadcast:    make(chan hubMessage, broadcastBufferSize),
+		register:     make(chan clientRegistration),
+		unregister:   make(chan *websocket.Conn),
+		done:         make(chan struct{}),
+		writeTimeout: writeTimeout,
+		sse:          newSSERegistry(),
+	}
 }
 
 func (h *websocketHub) run() {
 	// Main loop for handling client registration, unregistration, and broadcasting
 	for {
 		select {
-		case client := <-h.register:
+		case <-h.done:
+			return
+		case registration := <-h.register:
 			// Register new WebSocket client
 			h.mutex.Lock()
-			h.clients[client] = true
+			h.clients[registration.conn] = registration.state
+			metricWebSocketClients.Set(float64(len(h.clients)))
 			h.mutex.Unlock()
 			log.Println("WebSocket client registered")
 		case client := <-h.unregister:
 			// Unregister WebSocket client
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
+			if state, ok := h.clients[client]; ok {
+				// Keep the subscription for a reconnect of the client
+				h.sessions.save(state.clientID, state.devices)
 				delete(h.clients, client)
+				h.slots--
+				metricWebSocketClients.Set(float64(len(h.clients)))
 				client.Close(websocket.StatusNormalClosure, "unregister")
 				log.Println("WebSocket client unregistered")
 			}
 			h.mutex.Unlock()
 		case message := <-h.broadcast:
 			// Broadcast message to all connected clients
-			h.mutex.Lock()
-			msgBytes, err := json.Marshal(map[string]any{"type": "update", "payload": message})
-			if err != nil {
-				log.Printf("Error marshalling live update: %v", err)
-				break
+			msgBytes := message.raw
+			if msgBytes == nil {
+				var err error
+				if msgBytes, err = json.Marshal(message); err != nil {
+					log.Printf("Error marshalling %s message: %v", message.Type, err)
+					continue
+				}
 			}
-			for client := range h.clients {
-				err = client.Write(context.Background(), websocket.MessageText, msgBytes)
-				if err != nil {
-					log.Printf("Error writing to client: %v. Unregistering.", err)
-					go func(c *websocket.Conn) {
-						h.unregister <- c
-					}(client)
+			if message.Type == "update" {
+				// SSE clients only receive the location points
+				if data, err := json.Marshal(message.Payload); err == nil {
+					h.sse.broadcast(data)
+				}
+			}
+			// Write outside the lock and in parallel so a slow client doesn't delay the others
+			var clients []*websocket.Conn
+			h.mutex.Lock()
+			for client, state := range h.clients {
+				if message.deviceID == "" || state.wants(message.deviceID) {
+					clients = append(clients, client)
 				}
 			}
 			h.mutex.Unlock()
+			var wg sync.WaitGroup
+			for _, client := range clients {
+				wg.Add(1)
+				go func(c *websocket.Conn) {
+					defer wg.Done()
+					if err := h.write(c, msgBytes); err != nil {
+						log.Printf("Error writing to client: %v. Unregistering.", err)
+						// Skip the close handshake so unregistering doesn't wait on a stuck client
+						c.CloseNow()
+						go h.unregisterClient(c)
+					}
+				}(client)
+			}
+			wg.Wait()
 		}
 	}
 }
 
+// Write a message to a client, bounded by the hub's write timeout
+func (h *websocketHub) write(c *websocket.Conn, msg []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.writeTimeout)
+	defer cancel()
+	return c.Write(ctx, websocket.MessageText, msg)
+}
+
+// Set the devices a client is subscribed to, an empty list subscribes to all devices
+func (h *websocketHub) subscribe(c *websocket.Conn, devices []string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	state, ok := h.clients[c]
+	if !ok {
+		return
+	}
+	state.devices = deviceSet(devices)
+	h.clients[c] = state
+	h.sessions.save(state.clientID, state.devices)
+	log.Printf("WebSocket client subscribed to devices: %v", devices)
+}
+
+// Remember a point and queue it for broadcasting without blocking the caller
+func (h *websocketHub) publish(p locationPoint) {
+	h.recent.add(p)
+	h.mqtt.publish(p)
+	h.send(hubMessage{Type: "update", Payload: p, deviceID: p.DeviceID})
+}
+
+// Broadcast a control message that isn't tied to a location point to all WebSocket clients,
+// msg is sent as is and must contain its own type field
+func (h *websocketHub) broadcastControl(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshalling control message: %v", err)
+		return
+	}
+	h.send(hubMessage{Type: "control", raw: data})
+}
+
+// Queue a message for broadcasting without blocking the caller, a no-op once the hub is shut down
+func (h *websocketHub) send(msg hubMessage) {
+	select {
+	case <-h.done:
+		// Late messages during shutdown are discarded, the hub no longer delivers them
+		return
+	default:
+	}
+	select {
+	case h.broadcast <- msg:
+	default:
+		metricBroadcastsDropped.Inc()
+		log.Printf("Broadcast queue full, dropping %s message", msg.Type)
+	}
+}
+
+// Admit a new connection unless the hub is at capacity
+func (h *websocketHub) acquireSlot() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.maxClients > 0 && h.slots >= h.maxClients {
+		return false
+	}
+	h.slots++
+	return true
+}
+
+// Give back the slot of a connection that never got registered
+func (h *websocketHub) releaseSlot() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.slots--
+}
+
+// Request unregistration of a client, a no-op once the hub is shut down
+func (h *websocketHub) unregisterClient(c *websocket.Conn) {
+	select {
+	case h.unregister <- c:
+	case <-h.done:
+	}
+}
+
+// Stop the hub loop and close all clients with a going away status
+func (h *websocketHub) shutdown() {
+	close(h.done)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var wg sync.WaitGroup
+	for client := range h.clients {
+		wg.Add(1)
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			if h.reconnectJitter <= 0 {
+				c.Close(websocket.StatusGoingAway, "server shutting down")
+				return
+			}
+			// Each client waits a different time, so they don't all reconnect at once after a restart
+			delay := rand.N(h.reconnectJitter).Milliseconds()
+			if data, err := json.Marshal(reconnectMessage{Type: "reconnect", AfterMs: delay}); err == nil {
+				h.write(c, data)
+			}
+			c.Close(websocket.StatusServiceRestart, fmt.Sprintf("server restarting, reconnect after %d ms", delay))
+		}(client)
+	}
+	wg.Wait()
+	clear(h.clients)
+	h.slots = 0
+	metricWebSocketClients.Set(0)
+	log.Println("WebSocket hub shut down")
+}
+
 // Helper to get environment variable or fallback value
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
@@ -147,13 +715,410 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// Helper to get a boolean environment variable or fallback value
+func getEnvBool(key string, fallback bool) bool {
+	value := getEnv(key, strconv.FormatBool(fallback))
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default: %t", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Helper to get a float environment variable or fallback value
+func getEnvFloat(key string, fallback float64) float64 {
+	value := getEnv(key, strconv.FormatFloat(fallback, 'f', -1, 64))
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default: %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Valid SQLite journal and synchronous modes
+var (
+	sqliteJournalModes     = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+	speedUnits             = []string{"M/S", "KM/H", "MPH", "KN"}
+	lowQualityModes        = []string{"DROP", "FLAG"}
+)
+
+// Helper to check that an app name can be used as a quoted basic auth realm
+func validAppName(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return r == '"' || r == '\\' || unicode.IsControl(r)
+	})
+}
+
+// Helper to validate a case-insensitive choice, falls back with a warning for unknown values
+func validatedChoice(key, value, fallback string, choices []string) string {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	if slices.Contains(choices, upper) {
+		return upper
+	}
+	log.Printf("WARNING: Invalid value for %s: %q (valid: %s), using default: %s", key, value, strings.Join(choices, ", "), fallback)
+	return fallback
+}
+
+// Helper to normalize a URL path prefix to a leading and no trailing slash, empty for the root
+func normalizeBasePath(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "/")
+	if s == "" {
+		return ""
+	}
+	return "/" + s
+}
+
+// Helper to get an integer environment variable or fallback value
+func getEnvInt(key string, fallback int64) int64 {
+	value := getEnv(key, strconv.FormatInt(fallback, 10))
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default: %d", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
 func (a *app) loadConfig() {
 	// Load configuration from environment variables
+	a.config.appName = strings.TrimSpace(getEnv("LIVETRACKER_APP_NAME", defaultAppName))
+	if !validAppName(a.config.appName) {
+		log.Printf("LIVETRACKER_APP_NAME must not be empty or contain quotes, backslashes or control characters, using default: %s", defaultAppName)
+		a.config.appName = defaultAppName
+	}
 	a.config.port = getEnv("LIVETRACKER_PORT", "8080")
+	a.config.bindAddr = strings.Trim(strings.TrimSpace(os.Getenv("LIVETRACKER_BIND_ADDR")), "[]")
+	a.config.basePath = normalizeBasePath(os.Getenv("LIVETRACKER_BASE_PATH"))
 	a.config.dbPath = getEnv("LIVETRACKER_SQLITE_PATH", "tracker.db")
 	a.config.token = getEnv("LIVETRACKER_API_TOKEN", "default")
 	a.config.user = getEnv("LIVETRACKER_BASIC_AUTH_USER", "admin")
 	a.config.pass = getEnv("LIVETRACKER_BASIC_AUTH_PASS", "admin")
+	a.config.shareToken = os.Getenv("LIVETRACKER_SHARE_TOKEN")
+
+	a.config.sqliteBusyTimeout = getEnvInt("LIVETRACKER_SQLITE_BUSY_TIMEOUT", 1000)
+	if a.config.sqliteBusyTimeout < 0 {
+		log.Printf("LIVETRACKER_SQLITE_BUSY_TIMEOUT must not be negative, using default: 1000")
+		a.config.sqliteBusyTimeout = 1000
+	}
+	a.config.sqliteJournalMode = validatedChoice("LIVETRACKER_SQLITE_JOURNAL_MODE", getEnv("LIVETRACKER_SQLITE_JOURNAL_MODE", "WAL"), "WAL", sqliteJournalModes)
+	a.config.sqliteSynchronous = validatedChoice("LIVETRACKER_SQLITE_SYNCHRONOUS", getEnv("LIVETRACKER_SQLITE_SYNCHRONOUS", "NORMAL"), "NORMAL", sqliteSynchronousModes)
+	a.config.sqliteMaxOpenConns = getEnvInt("LIVETRACKER_SQLITE_MAX_OPEN_CONNS", 1)
+	if a.config.sqliteMaxOpenConns < 0 {
+		log.Printf("LIVETRACKER_SQLITE_MAX_OPEN_CONNS must not be negative, using default: 1")
+		a.config.sqliteMaxOpenConns = 1
+	}
+	a.config.sqliteMaxIdleConns = getEnvInt("LIVETRACKER_SQLITE_MAX_IDLE_CONNS", 1)
+	if a.config.sqliteMaxIdleConns < 0 {
+		log.Printf("LIVETRACKER_SQLITE_MAX_IDLE_CONNS must not be negative, using default: 1")
+		a.config.sqliteMaxIdleConns = 1
+	}
+	a.config.sqliteConnMaxLifetime = time.Duration(getEnvInt("LIVETRACKER_SQLITE_CONN_MAX_LIFETIME_SECONDS", 0)) * time.Second
+	if a.config.sqliteConnMaxLifetime < 0 {
+		log.Printf("LIVETRACKER_SQLITE_CONN_MAX_LIFETIME_SECONDS must not be negative, using default: 0")
+		a.config.sqliteConnMaxLifetime = 0
+	}
+	a.config.sqliteMmapSizeMB = getEnvInt("LIVETRACKER_SQLITE_MMAP_SIZE_MB", 0)
+	if a.config.sqliteMmapSizeMB < 0 {
+		log.Printf("LIVETRACKER_SQLITE_MMAP_SIZE_MB must not be negative, using default: 0")
+		a.config.sqliteMmapSizeMB = 0
+	}
+	a.config.sqliteCacheSizeMB = getEnvInt("LIVETRACKER_SQLITE_CACHE_SIZE_MB", 0)
+	if a.config.sqliteCacheSizeMB < 0 {
+		log.Printf("LIVETRACKER_SQLITE_CACHE_SIZE_MB must not be negative, using default: 0")
+		a.config.sqliteCacheSizeMB = 0
+	}
+	// On by default, with a single writer connection a slow export download would otherwise
+	// hold the only connection and stall inserts and the health check
+	a.config.sqliteReadPool = getEnvBool("LIVETRACKER_SQLITE_READ_POOL", true)
+	a.config.sqliteReadMaxOpenConns = getEnvInt("LIVETRACKER_SQLITE_READ_MAX_OPEN_CONNS", 4)
+	if a.config.sqliteReadMaxOpenConns < 0 {
+		log.Printf("LIVETRACKER_SQLITE_READ_MAX_OPEN_CONNS must not be negative, using default: 4")
+		a.config.sqliteReadMaxOpenConns = 4
+	}
+	a.config.walCheckpointInterval = time.Duration(getEnvInt("LIVETRACKER_WAL_CHECKPOINT_MINUTES", 0)) * time.Minute
+	if a.config.walCheckpointInterval < 0 {
+		log.Printf("LIVETRACKER_WAL_CHECKPOINT_MINUTES must not be negative, using default: 0")
+		a.config.walCheckpointInterval = 0
+	}
+	a.config.dbTimeout = time.Duration(getEnvInt("LIVETRACKER_DB_TIMEOUT_SECONDS", 10)) * time.Second
+	if a.config.dbTimeout < 0 {
+		log.Printf("LIVETRACKER_DB_TIMEOUT_SECONDS must not be negative, using default: 10")
+		a.config.dbTimeout = 10 * time.Second
+	}
+	a.config.dbOpenAttempts = getEnvInt("LIVETRACKER_DB_OPEN_ATTEMPTS", 5)
+	if a.config.dbOpenAttempts < 1 {
+		log.Printf("LIVETRACKER_DB_OPEN_ATTEMPTS must be at least 1, using default: 5")
+		a.config.dbOpenAttempts = 5
+	}
+	a.config.dbOpenRetryBackoff = time.Duration(getEnvInt("LIVETRACKER_DB_OPEN_RETRY_BACKOFF_MS", 1000)) * time.Millisecond
+	a.config.insertAttempts = getEnvInt("LIVETRACKER_INSERT_ATTEMPTS", 3)
+	if a.config.insertAttempts < 1 {
+		log.Printf("LIVETRACKER_INSERT_ATTEMPTS must be at least 1, using default: 3")
+		a.config.insertAttempts = 3
+	}
+	a.config.insertRetryBackoff = time.Duration(getEnvInt("LIVETRACKER_INSERT_RETRY_BACKOFF_MS", 50)) * time.Millisecond
+
+	a.config.deriveBearing = getEnvBool("LIVETRACKER_DERIVE_BEARING", false)
+	a.config.minDistanceMeters = getEnvFloat("LIVETRACKER_MIN_DISTANCE_METERS", 0)
+	if !(a.config.minDistanceMeters >= 0) {
+		log.Printf("LIVETRACKER_MIN_DISTANCE_METERS must not be negative, using default: 0")
+		a.config.minDistanceMeters = 0
+	}
+	a.config.dedupeMaxInterval = time.Duration(getEnvInt("LIVETRACKER_DEDUPE_MAX_SECONDS", 300)) * time.Second
+	if a.config.dedupeMaxInterval <= 0 {
+		log.Printf("LIVETRACKER_DEDUPE_MAX_SECONDS must be positive, using default: 300")
+		a.config.dedupeMaxInterval = 300 * time.Second
+	}
+	a.config.dedupeBroadcast = getEnvBool("LIVETRACKER_DEDUPE_BROADCAST", true)
+	a.config.maxHDOP = getEnvFloat("LIVETRACKER_MAX_HDOP", 0)
+	if !(a.config.maxHDOP >= 0) {
+		log.Printf("LIVETRACKER_MAX_HDOP must not be negative, using default: 0")
+		a.config.maxHDOP = 0
+	}
+	a.config.lowQualityMode = validatedChoice("LIVETRACKER_LOW_QUALITY_MODE", getEnv("LIVETRACKER_LOW_QUALITY_MODE", "DROP"), "DROP", lowQualityModes)
+	a.config.requireAccuracy = getEnvBool("LIVETRACKER_REQUIRE_ACCURACY", false)
+	a.config.speedUnit = validatedChoice("LIVETRACKER_SPEED_UNIT", getEnv("LIVETRACKER_SPEED_UNIT", "M/S"), "M/S", speedUnits)
+
+	a.config.historySeconds = getEnvInt("LIVETRACKER_HISTORY_SECONDS", 10800)
+	a.config.maxHistorySeconds = getEnvInt("LIVETRACKER_HISTORY_MAX_SECONDS", 604800)
+	if a.config.historySeconds <= 0 {
+		log.Printf("LIVETRACKER_HISTORY_SECONDS must be positive, using default: 10800")
+		a.config.historySeconds = 10800
+	}
+	if a.config.maxHistorySeconds < a.config.historySeconds {
+		log.Printf("LIVETRACKER_HISTORY_MAX_SECONDS is lower than LIVETRACKER_HISTORY_SECONDS, using %d", a.config.historySeconds)
+		a.config.maxHistorySeconds = a.config.historySeconds
+	}
+	a.config.historyChunkSize = getEnvInt("LIVETRACKER_HISTORY_CHUNK_SIZE", 500)
+	if a.config.historyChunkSize <= 0 {
+		log.Printf("LIVETRACKER_HISTORY_CHUNK_SIZE must be positive, using default: 500")
+		a.config.historyChunkSize = 500
+	}
+	a.config.tripGap = time.Duration(getEnvInt("LIVETRACKER_TRIP_GAP_MINUTES", 30)) * time.Minute
+	if a.config.tripGap <= 0 {
+		log.Printf("LIVETRACKER_TRIP_GAP_MINUTES must be positive, using default: 30")
+		a.config.tripGap = 30 * time.Minute
+	}
+	a.config.elevationThreshold = getEnvFloat("LIVETRACKER_ELEVATION_THRESHOLD_METERS", 5)
+	if !(a.config.elevationThreshold >= 0) {
+		log.Printf("LIVETRACKER_ELEVATION_THRESHOLD_METERS must not be negative, using default: 5")
+		a.config.elevationThreshold = 5
+	}
+	a.config.resetOnTrip = getEnvBool("LIVETRACKER_RESET_ON_TRIP", false)
+
+	a.config.mapCenterLat = getEnvFloat("LIVETRACKER_MAP_CENTER_LAT", 51.505)
+	a.config.mapCenterLon = getEnvFloat("LIVETRACKER_MAP_CENTER_LON", -0.09)
+	if !(a.config.mapCenterLat >= -90 && a.config.mapCenterLat <= 90) || !(a.config.mapCenterLon >= -180 && a.config.mapCenterLon <= 180) {
+		log.Printf("LIVETRACKER_MAP_CENTER_LAT/LON out of range, using default: 51.505, -0.09")
+		a.config.mapCenterLat, a.config.mapCenterLon = 51.505, -0.09
+	}
+	a.config.mapZoom = getEnvInt("LIVETRACKER_MAP_ZOOM", 13)
+	if a.config.mapZoom < 0 || a.config.mapZoom > 19 {
+		log.Printf("LIVETRACKER_MAP_ZOOM must be between 0 and 19, using default: 13")
+		a.config.mapZoom = 13
+	}
+	a.config.tileURL = getEnv("LIVETRACKER_TILE_URL", defaultTileURL)
+	if !validTileURL(a.config.tileURL) {
+		log.Printf("LIVETRACKER_TILE_URL must contain {z}, {x} and {y}, using default: %s", defaultTileURL)
+		a.config.tileURL = defaultTileURL
+	}
+	a.config.tileAttribution = getEnv("LIVETRACKER_TILE_ATTRIBUTION", defaultTileAttribution)
+
+	a.config.batchSize = getEnvInt("LIVETRACKER_BATCH_SIZE", 0)
+	a.config.batchInterval = time.Duration(getEnvInt("LIVETRACKER_BATCH_INTERVAL_MS", 1000)) * time.Millisecond
+	if a.config.batchInterval <= 0 {
+		log.Printf("LIVETRACKER_BATCH_INTERVAL_MS must be positive, using default: 1000")
+		a.config.batchInterval = time.Second
+	}
+
+	a.config.wsPingInterval = time.Duration(getEnvInt("LIVETRACKER_WS_PING_SECONDS", 30)) * time.Second
+	a.config.wsCompression = getEnvBool("LIVETRACKER_WS_COMPRESSION", true)
+	a.config.maxWSClients = getEnvInt("LIVETRACKER_MAX_WS_CLIENTS", 0)
+	if a.config.maxWSClients < 0 {
+		log.Printf("LIVETRACKER_MAX_WS_CLIENTS must not be negative, using default: 0")
+		a.config.maxWSClients = 0
+	}
+	a.config.recentBuffer = getEnvInt("LIVETRACKER_RECENT_BUFFER", 1000)
+	if a.config.recentBuffer < 0 {
+		log.Printf("LIVETRACKER_RECENT_BUFFER must not be negative, using default: 1000")
+		a.config.recentBuffer = 1000
+	}
+	a.config.wsSessionTTL = time.Duration(getEnvInt("LIVETRACKER_WS_SESSION_TTL_SECONDS", 600)) * time.Second
+	if a.config.wsSessionTTL < 0 {
+		log.Printf("LIVETRACKER_WS_SESSION_TTL_SECONDS must not be negative, using default: 600")
+		a.config.wsSessionTTL = 600 * time.Second
+	}
+	a.config.reconnectJitter = time.Duration(getEnvInt("LIVETRACKER_RECONNECT_JITTER_SECONDS", 10)) * time.Second
+	if a.config.reconnectJitter < 0 {
+		log.Printf("LIVETRACKER_RECONNECT_JITTER_SECONDS must not be negative, using default: 10")
+		a.config.reconnectJitter = 10 * time.Second
+	}
+	a.config.wsWriteTimeout = time.Duration(getEnvInt("LIVETRACKER_WS_WRITE_TIMEOUT_SECONDS", 5)) * time.Second
+	if a.config.wsWriteTimeout <= 0 {
+		log.Printf("LIVETRACKER_WS_WRITE_TIMEOUT_SECONDS must be positive, using default: 5")
+		a.config.wsWriteTimeout = 5 * time.Second
+	}
+
+	a.config.sseKeepaliveInterval = time.Duration(getEnvInt("LIVETRACKER_SSE_KEEPALIVE_SECONDS", 30)) * time.Second
+
+	a.config.onlineThreshold = time.Duration(getEnvInt("LIVETRACKER_ONLINE_THRESHOLD_SECONDS", 300)) * time.Second
+
+	a.config.retentionDays = getEnvInt("LIVETRACKER_RETENTION_DAYS", 0)
+	a.config.retentionVacuum = getEnvBool("LIVETRACKER_RETENTION_VACUUM", false)
+
+	a.config.metricsAuth = getEnvBool("LIVETRACKER_METRICS_AUTH", true)
+	a.config.pprof = getEnvBool("LIVETRACKER_PPROF", false)
+	a.config.onPointCmd = os.Getenv("LIVETRACKER_ON_POINT_CMD")
+	a.config.onPointTimeout = time.Duration(getEnvInt("LIVETRACKER_ON_POINT_TIMEOUT_SECONDS", 10)) * time.Second
+	if a.config.onPointTimeout <= 0 {
+		log.Printf("LIVETRACKER_ON_POINT_TIMEOUT_SECONDS must be positive, using default: 10")
+		a.config.onPointTimeout = 10 * time.Second
+	}
+
+	a.config.mqttURL = os.Getenv("LIVETRACKER_MQTT_URL")
+	a.config.mqttTopic = strings.TrimSuffix(getEnv("LIVETRACKER_MQTT_TOPIC", "livetracker"), "/")
+	if a.config.mqttTopic == "" || strings.ContainsAny(a.config.mqttTopic, "+#") {
+		log.Printf("LIVETRACKER_MQTT_TOPIC must not be empty or contain wildcards, using default: livetracker")
+		a.config.mqttTopic = "livetracker"
+	}
+	a.config.mqttClientID = getEnv("LIVETRACKER_MQTT_CLIENT_ID", "livetracker")
+	a.config.mqttUser = os.Getenv("LIVETRACKER_MQTT_USERNAME")
+	a.config.mqttPass = os.Getenv("LIVETRACKER_MQTT_PASSWORD")
+	a.config.mqttRetain = getEnvBool("LIVETRACKER_MQTT_RETAIN", true)
+	a.config.accessLog = getEnvBool("LIVETRACKER_ACCESS_LOG", false)
+	a.config.debug = getEnvBool("LIVETRACKER_DEBUG", false)
+	a.config.gzip = getEnvBool("LIVETRACKER_GZIP", true)
+
+	a.config.corsOrigins = parseCORSOrigins(os.Getenv("LIVETRACKER_CORS_ORIGINS"))
+
+	a.config.tlsCert = os.Getenv("LIVETRACKER_TLS_CERT")
+	a.config.tlsKey = os.Getenv("LIVETRACKER_TLS_KEY")
+	if (a.config.tlsCert == "") != (a.config.tlsKey == "") {
+		log.Fatalf("Both LIVETRACKER_TLS_CERT and LIVETRACKER_TLS_KEY are required to enable HTTPS")
+	}
+
+	a.config.readHeaderTimeout = time.Duration(getEnvInt("LIVETRACKER_READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second
+	if a.config.readHeaderTimeout < 0 {
+		log.Printf("LIVETRACKER_READ_HEADER_TIMEOUT_SECONDS must not be negative, using default: 10")
+		a.config.readHeaderTimeout = 10 * time.Second
+	}
+	a.config.readTimeout = time.Duration(getEnvInt("LIVETRACKER_READ_TIMEOUT_SECONDS", 60)) * time.Second
+	if a.config.readTimeout < 0 {
+		log.Printf("LIVETRACKER_READ_TIMEOUT_SECONDS must not be negative, using default: 60")
+		a.config.readTimeout = 60 * time.Second
+	}
+	a.config.writeTimeout = time.Duration(getEnvInt("LIVETRACKER_WRITE_TIMEOUT_SECONDS", 60)) * time.Second
+	if a.config.writeTimeout < 0 {
+		log.Printf("LIVETRACKER_WRITE_TIMEOUT_SECONDS must not be negative, using default: 60")
+		a.config.writeTimeout = 60 * time.Second
+	}
+	a.config.idleTimeout = time.Duration(getEnvInt("LIVETRACKER_IDLE_TIMEOUT_SECONDS", 120)) * time.Second
+	if a.config.idleTimeout < 0 {
+		log.Printf("LIVETRACKER_IDLE_TIMEOUT_SECONDS must not be negative, using default: 120")
+		a.config.idleTimeout = 120 * time.Second
+	}
+	a.config.shutdownTimeout = time.Duration(getEnvInt("LIVETRACKER_SHUTDOWN_TIMEOUT_SECONDS", 5)) * time.Second
+	if a.config.shutdownTimeout <= 0 {
+		log.Printf("LIVETRACKER_SHUTDOWN_TIMEOUT_SECONDS must be positive, using default: 5")
+		a.config.shutdownTimeout = 5 * time.Second
+	}
+	a.config.h2c = getEnvBool("LIVETRACKER_H2C", false)
+
+	a.config.rateLimit = getEnvFloat("LIVETRACKER_RATE_LIMIT", 0)
+	a.config.rateBurst = getEnvInt("LIVETRACKER_RATE_BURST", 10)
+	trustedProxies, err := parseNetworks(os.Getenv("LIVETRACKER_TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid LIVETRACKER_TRUSTED_PROXIES: %v", err)
+	}
+	if len(trustedProxies) == 0 && getEnvBool("LIVETRACKER_TRUST_PROXY", false) {
+		log.Printf("WARNING: LIVETRACKER_TRUST_PROXY is deprecated and trusts forwarding headers from any peer, use LIVETRACKER_TRUSTED_PROXIES instead")
+		trustedProxies, _ = parseNetworks("0.0.0.0/0,::/0")
+	}
+	a.config.trustedProxies = trustedProxies
+	authSkipNetworks, err := parseNetworks(os.Getenv("LIVETRACKER_AUTH_SKIP_CIDRS"))
+	if err != nil {
+		log.Fatalf("Invalid LIVETRACKER_AUTH_SKIP_CIDRS: %v", err)
+	}
+	a.config.authSkipNetworks = authSkipNetworks
+	if len(authSkipNetworks) > 0 {
+		log.Printf("Skipping basic authentication of the web interface for clients from %s", os.Getenv("LIVETRACKER_AUTH_SKIP_CIDRS"))
+	}
+
+	a.config.maxFutureSkew = time.Duration(getEnvInt("LIVETRACKER_MAX_FUTURE_SKEW_SECONDS", 0)) * time.Second
+	a.config.labelParam = getEnv("LIVETRACKER_LABEL_PARAM", "label")
+	a.config.maxTrackBodyBytes = getEnvInt("LIVETRACKER_MAX_TRACK_BODY_BYTES", defaultMaxTrackBodyBytes)
+	if a.config.maxTrackBodyBytes <= 0 {
+		log.Printf("LIVETRACKER_MAX_TRACK_BODY_BYTES must be positive, using default: %d", defaultMaxTrackBodyBytes)
+		a.config.maxTrackBodyBytes = defaultMaxTrackBodyBytes
+	}
+	a.config.maxTrackQueryBytes = getEnvInt("LIVETRACKER_MAX_TRACK_QUERY_BYTES", defaultMaxTrackQueryBytes)
+	if a.config.maxTrackQueryBytes <= 0 {
+		log.Printf("LIVETRACKER_MAX_TRACK_QUERY_BYTES must be positive, using default: %d", defaultMaxTrackQueryBytes)
+		a.config.maxTrackQueryBytes = defaultMaxTrackQueryBytes
+	}
+	a.config.strictJSON = getEnvBool("LIVETRACKER_STRICT_JSON", false)
+	a.config.ownTracksStrictJSON = getEnvBool("LIVETRACKER_OWNTRACKS_STRICT_JSON", false)
+
+	geofences, err := parseGeofences(os.Getenv("LIVETRACKER_GEOFENCES"))
+	if err != nil {
+		log.Fatalf("Invalid LIVETRACKER_GEOFENCES: %v", err)
+	}
+	a.config.geofences = geofences
+	a.config.webhookURL = os.Getenv("LIVETRACKER_WEBHOOK_URL")
+	if len(geofences) > 0 {
+		log.Printf("Loaded %d geofence(s)", len(geofences))
+	}
+
+	a.config.geocodeURL = os.Getenv("LIVETRACKER_GEOCODE_URL")
+	if u, err := url.Parse(a.config.geocodeURL); a.config.geocodeURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+		log.Fatalf("LIVETRACKER_GEOCODE_URL must be an http or https URL")
+	}
+
+	a.config.staticDir = os.Getenv("LIVETRACKER_STATIC_DIR")
+
+	a.config.demo = getEnvBool("LIVETRACKER_DEMO", false)
+	a.config.demoInterval = time.Duration(getEnvInt("LIVETRACKER_DEMO_INTERVAL_SECONDS", 2)) * time.Second
+	if a.config.demoInterval <= 0 {
+		log.Printf("LIVETRACKER_DEMO_INTERVAL_SECONDS must be positive, using default: 2")
+		a.config.demoInterval = 2 * time.Second
+	}
+
+	devices, err := parseDevices(os.Getenv("LIVETRACKER_DEVICES"))
+	if err != nil {
+		log.Fatalf("Invalid LIVETRACKER_DEVICES: %v", err)
+	}
+	a.config.devices = devices
+	if len(devices) > 0 {
+		log.Printf("Registered %d device(s)", len(devices))
+	}
+	a.config.tokensFile = os.Getenv("LIVETRACKER_TOKENS_FILE")
+	if a.config.tokensFile != "" {
+		if err := a.reloadTokensFile(); err != nil {
+			log.Fatalf("Invalid LIVETRACKER_TOKENS_FILE: %v", err)
+		}
+	}
+	if len(devices) > 0 || a.config.tokensFile != "" {
+		if _, ok := os.LookupEnv("LIVETRACKER_API_TOKEN"); !ok {
+			// Don't accept the insecure default token when devices are configured
+			a.config.token = ""
+		}
+	}
+
+	a.config.apiTokenFile = os.Getenv("LIVETRACKER_API_TOKEN_FILE")
+	if a.config.apiTokenFile != "" {
+		// A rotated token takes precedence over the environment
+		token, err := readTokenFile(a.config.apiTokenFile)
+		if err != nil {
+			log.Fatalf("Invalid LIVETRACKER_API_TOKEN_FILE: %v", err)
+		}
+		if token != "" {
+			a.config.token = token
+			log.Printf("Using API token from %s", a.config.apiTokenFile)
+		}
+	}
 
 	if a.config.token == "default" {
 		log.Println("WARNING: LIVETRACKER_API_TOKEN is set to its default value. Please set a secure token via environment variable.")
@@ -163,8 +1128,24 @@ func (a *app) loadConfig() {
 	}
 }
 
-func (a *app) initDB() {
-	// Initialize SQLite database and apply migrations
+// Helper to describe a connection limit where zero means unlimited
+func poolLimit(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// Helper to describe a connection lifetime where zero means unlimited
+func poolLifetime(d time.Duration) string {
+	if d <= 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
+
+func (a *app) openDB() {
+	// Open the SQLite database without applying migrations
 	dbFile := a.config.dbPath
 	if strings.Contains(dbFile, "?") {
 		dbFile += "&"
@@ -174,22 +1155,72 @@ func (a *app) initDB() {
 	dbParams := make(url.Values)
 	dbParams.Add("mode", "rwc")
 	dbParams.Add("_txlock", "immediate")
-	dbParams.Add("_journal_mode", "WAL")
-	dbParams.Add("_busy_timeout", "1000")
-	dbParams.Add("_synchronous", "NORMAL")
+	dbParams.Add("_journal_mode", a.config.sqliteJournalMode)
+	dbParams.Add("_busy_timeout", strconv.FormatInt(a.config.sqliteBusyTimeout, 10))
+	dbParams.Add("_synchronous", a.config.sqliteSynchronous)
 
-	var err error
-	a.db, err = sql.Open("sqlite3", dbFile+dbParams.Encode())
-	if err != nil {
+	if err := a.connectDBWithRetry(dbFile + dbParams.Encode()); err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
+	log.Printf("SQLite connection pool: max open %s, max idle %d, max lifetime %s",
+		poolLimit(a.config.sqliteMaxOpenConns), a.config.sqliteMaxIdleConns, poolLifetime(a.config.sqliteConnMaxLifetime))
+	a.logSQLiteTuning()
+}
+
+// Open and ping the database, transient errors like a full disk or a locked file are retried with
+// exponential backoff, configuration errors like a missing directory fail immediately
+func (a *app) connectDBWithRetry(dsn string) error {
+	backoff := a.config.dbOpenRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := a.connectDB(dsn)
+		if err == nil {
+			return nil
+		}
+		if fatalDBOpenError(a.config.dbPath, err) || attempt >= int(a.config.dbOpenAttempts) {
+			return err
+		}
+		log.Printf("Database not available (attempt %d of %d), retrying in %s: %v", attempt, a.config.dbOpenAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Helper to open the database with the configured pool settings and check the connection
+func (a *app) connectDB(dsn string) error {
+	db := sql.OpenDB(a.sqliteConnector(dsn))
+	// SQLite serializes writes, additional connections only add lock contention between writers
+	db.SetMaxOpenConns(int(a.config.sqliteMaxOpenConns))
+	db.SetMaxIdleConns(int(a.config.sqliteMaxIdleConns))
+	db.SetConnMaxLifetime(a.config.sqliteConnMaxLifetime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	a.db = db
+	return nil
+}
 
-	if err = a.db.Ping(); err != nil {
-		log.Fatalf("Error pinging database: %v", err)
+// Check whether opening the database failed for a reason retrying won't fix: the directory of the
+// database file doesn't exist or the file isn't a usable SQLite database
+func fatalDBOpenError(dbPath string, err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrNotADB || sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrMisuse) {
+		return true
+	}
+	path, _, _ := strings.Cut(strings.TrimPrefix(dbPath, "file:"), "?")
+	if path == "" || path == ":memory:" {
+		return false
 	}
+	_, statErr := os.Stat(filepath.Dir(path))
+	return errors.Is(statErr, fs.ErrNotExist)
+}
+
+func (a *app) initDB() {
+	// Initialize SQLite database and apply migrations
+	a.openDB()
 
 	log.Println("Starting database migrations...")
-	_, err = a.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (id TEXT PRIMARY KEY);`)
+	_, err := a.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (id TEXT PRIMARY KEY);`)
 	if err != nil {
 		log.Fatalf("Failed to create schema_migrations table: %v", err)
 	}
@@ -242,13 +1273,143 @@ func (a *app) initDB() {
 		}
 	}
 	log.Println("Database migrations finished.")
+	if status, err := a.migrationStatuses(context.Background()); err == nil {
+		log.Printf("Database schema version: %s", status.SchemaVersion)
+	}
 	log.Println("Database initialized successfully.")
 
-	stmt, err := a.db.Prepare("INSERT INTO locations(latitude, longitude, altitude, speed, bearing, accuracy_hdop, timestamp) VALUES(?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := a.db.Prepare("INSERT INTO locations(latitude, longitude, altitude, speed, bearing, accuracy_hdop, timestamp, device_id, bearing_derived, battery, satellites, low_quality, label) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		log.Fatalf("Error preparing insert statement: %v", err)
 	}
 	a.insertLocationStmt = stmt
+
+	if a.config.sqliteReadPool {
+		a.openReadDB()
+	}
+}
+
+// Open a second, read-only connection pool on the same database file for queries, so long history
+// and export reads don't wait for the writer connection. Opened after the migrations, because a
+// read-only connection can't create the database.
+func (a *app) openReadDB() {
+	dbFile := a.config.dbPath
+	// SQLite only honors the mode parameter for URI filenames
+	if !strings.HasPrefix(dbFile, "file:") {
+		dbFile = "file:" + dbFile
+	}
+	if strings.Contains(dbFile, "?") {
+		dbFile += "&"
+	} else {
+		dbFile += "?"
+	}
+	dbParams := make(url.Values)
+	dbParams.Add("mode", "ro")
+	dbParams.Add("_busy_timeout", strconv.FormatInt(a.config.sqliteBusyTimeout, 10))
+	db := sql.OpenDB(a.sqliteConnector(dbFile + dbParams.Encode()))
+	db.SetMaxOpenConns(int(a.config.sqliteReadMaxOpenConns))
+	db.SetMaxIdleConns(int(max(a.config.sqliteMaxIdleConns, a.config.sqliteReadMaxOpenConns)))
+	db.SetConnMaxLifetime(a.config.sqliteConnMaxLifetime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		log.Fatalf("Error opening read-only database: %v", err)
+	}
+	a.readDB = db
+	log.Printf("SQLite read pool: max open %s", poolLimit(a.config.sqliteReadMaxOpenConns))
+}
+
+// Helper to get the pool for read queries, the writer pool when no read pool is configured
+func (a *app) reader() *sql.DB {
+	if a.readDB != nil {
+		return a.readDB
+	}
+	return a.db
+}
+
+// Helper to insert a location point using the given insert statement
+func insertLocation(ctx context.Context, stmt *sql.Stmt, p locationPoint) error {
+	timer := prometheus.NewTimer(metricInsertDuration)
+	defer timer.ObserveDuration()
+	_, err := stmt.ExecContext(ctx, p.Latitude, p.Longitude, p.Altitude, p.Speed, p.Bearing, p.Accuracy, p.Timestamp, p.DeviceID, p.BearingDerived, p.Battery, p.Satellites, p.LowQuality, p.Label)
+	return err
+}
+
+func (a *app) startBatchWriter() {
+	// Start the write-behind buffer if batching is enabled
+	if a.config.batchSize <= 1 {
+		return
+	}
+	a.batch = newBatchWriter(a.db, a.insertLocationStmt, int(a.config.batchSize), a.config.batchInterval)
+	a.batch.health = &a.writeHealth
+	go a.batch.run()
+	log.Printf("Batch inserts enabled: %d points or every %s", a.config.batchSize, a.config.batchInterval)
+}
+
+// Helper to parse a device list in the form "id1:token1,id2:token2"
+func parseDevices(s string) (map[string]string, error) {
+	devices := make(map[string]string)
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := addDeviceEntry(devices, entry); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// Helper to add an "id:token" entry to a map of tokens to device IDs
+func addDeviceEntry(devices map[string]string, entry string) error {
+	id, token, ok := strings.Cut(entry, ":")
+	id, token = strings.TrimSpace(id), strings.TrimSpace(token)
+	if !ok || id == "" || token == "" {
+		return fmt.Errorf("invalid device entry %q, expected id:token", entry)
+	}
+	if _, exists := devices[token]; exists {
+		return fmt.Errorf("duplicate token for device %q", id)
+	}
+	devices[token] = id
+	return nil
+}
+
+// Resolve an API token to a device ID
+func (a *app) deviceForToken(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	if id, ok := a.tokenFile.lookup(token); ok {
+		return id, true
+	}
+	if id, ok := lookupToken(a.config.devices, token); ok {
+		return id, true
+	}
+	if current := a.currentToken(); current != "" && subtle.ConstantTimeCompare([]byte(token), []byte(current)) == 1 {
+		return defaultDeviceID, true
+	}
+	return "", false
+}
+
+// Helper to find the device of a token, comparing against every token in constant time
+// so the response time doesn't reveal how much of a token matched
+func lookupToken(devices map[string]string, token string) (string, bool) {
+	var id string
+	found := false
+	for candidate, device := range devices {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			id, found = device, true
+		}
+	}
+	return id, found
+}
+
+// Helper to describe the listen address for the startup log
+func describeBindAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return addr + " (all interfaces)"
+	}
+	return addr
 }
 
 // Helper to parse float from string or return nil
@@ -263,14 +1424,38 @@ func parseFloatOrNil(s string) *float64 {
 	return &val
 }
 
+// Helper to parse integer from string or return nil
+func parseIntOrNil(s string) *int64 {
+	if s == "" {
+		return nil
+	}
+	val, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &val
+}
+
+// Helper to trim a string or return nil when it is empty
+func stringOrNil(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
 func (a *app) trackHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle incoming location tracking requests
+	if int64(len(r.URL.RawQuery)) > a.config.maxTrackQueryBytes {
+		metricPointsRejected.WithLabelValues("invalid").Inc()
+		http.Error(w, "Query string too long", http.StatusRequestURITooLong)
+		return
+	}
 	query := r.URL.Query()
 
-	token := query.Get("token")
-	if token != a.config.token {
-		http.Error(w, "Invalid API token", http.StatusUnauthorized)
-		log.Printf("Unauthorized access attempt with token: %s from %s", token, r.RemoteAddr)
+	deviceID, ok := a.authenticateDevice(w, r)
+	if !ok {
 		return
 	}
 
@@ -293,48 +1478,181 @@ func (a *app) trackHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid longitude", http.StatusBadRequest)
 		return
 	}
-	timestamp, err := strconv.ParseInt(tsStr, 10, 64)
+	timestamp, err := parseTimestamp(tsStr)
 	if err != nil {
 		http.Error(w, "Invalid timestamp", http.StatusBadRequest)
 		return
 	}
 
 	point := locationPoint{
-		Latitude:  lat,
-		Longitude: lon,
-		Timestamp: timestamp,
-		Altitude:  parseFloatOrNil(query.Get("altitude")),
-		Speed:     parseFloatOrNil(query.Get("speed")),
-		Bearing:   parseFloatOrNil(query.Get("bearing")),
-		Accuracy:  parseFloatOrNil(query.Get("hdop")),
-	}
-
-	stmt := a.insertLocationStmt
-	if stmt == nil {
-		log.Printf("Insert statement not prepared")
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		Latitude:   lat,
+		Longitude:  lon,
+		Timestamp:  timestamp,
+		Altitude:   parseFloatOrNil(query.Get("altitude")),
+		Speed:      parseFloatOrNil(query.Get("speed")),
+		Bearing:    parseFloatOrNil(query.Get("bearing")),
+		Accuracy:   parseFloatOrNil(query.Get("hdop")),
+		DeviceID:   deviceID,
+		Battery:    parseFloatOrNil(query.Get("batt")),
+		Satellites: parseIntOrNil(query.Get("sats")),
+	}
+	if a.config.labelParam != "" {
+		point.Label = stringOrNil(query.Get(a.config.labelParam))
+	}
+	a.normalizeSpeed(&point)
+
+	if err := a.validateLocation(point); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		metricPointsRejected.WithLabelValues("invalid").Inc()
 		return
 	}
 
-	_, err = stmt.Exec(point.Latitude, point.Longitude, point.Altitude, point.Speed, point.Bearing, point.Accuracy, point.Timestamp)
+	// The device gets its response before the point is broadcast, so a slow broadcast doesn't make it resend
+	point, stored, err := a.insertPoint(r.Context(), point)
 	if err != nil {
 		log.Printf("Error saving location: %v", err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		writeStoreError(w, err)
+		return
+	}
+	writeTrackResponse(w, r, point)
+	if stored {
+		http.NewResponseController(w).Flush()
+		a.announceLocation(point)
+	}
+}
+
+// Helper to extract the API token from an Authorization bearer or X-API-Token header, falling back
+// to the query that OsmAnd uses. Headers come first as they don't end up in access logs.
+func tokenFromRequest(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if token := strings.TrimSpace(r.Header.Get("X-API-Token")); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Helper to make a token safe for logging, the hash prefix still allows correlating attempts
+func redactToken(token string) string {
+	if token == "" {
+		return "(empty)"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("sha256:%x (len %d)", sum[:4], len(token))
+}
+
+// Authenticate a tracking request and resolve its device ID, writes a 401 on failure
+func (a *app) authenticateDevice(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := tokenFromRequest(r)
+	deviceID, ok := a.deviceForToken(token)
+	if !ok {
+		http.Error(w, "Invalid API token", http.StatusUnauthorized)
+		metricPointsRejected.WithLabelValues("token").Inc()
+		log.Printf("Unauthorized access attempt with token %s from %s", redactToken(token), a.clientIP(r))
+		return "", false
+	}
+	return deviceID, true
+}
+
+// Helper to answer a location that couldn't be stored, with 503 during shutdown so the device retries later
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errShuttingDown) {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
+	http.Error(w, "Server error", http.StatusInternalServerError)
+}
 
-	log.Printf("Received location: Lat %f, Lon %f, TS %d", point.Latitude, point.Longitude, point.Timestamp)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Location received"))
+// Helper to log a message only when debug logging is enabled
+func (a *app) debugf(format string, args ...any) {
+	if a.config.debug {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
 
-	a.hub.broadcast <- point
+// Store a location point (directly or via the batch writer) and broadcast it to WebSocket clients,
+// returns the point as stored including server-derived fields, ctx bounds the direct insert
+func (a *app) storeLocation(ctx context.Context, point locationPoint) (locationPoint, error) {
+	point, stored, err := a.insertPoint(ctx, point)
+	if err == nil && stored {
+		a.announceLocation(point)
+	}
+	return point, err
+}
+
+// Insert a location point without announcing it, the bool is false for dropped low-quality fixes and
+// skipped duplicates
+func (a *app) insertPoint(ctx context.Context, point locationPoint) (locationPoint, bool, error) {
+	// The database records its own receive time, this one is only sent to clients
+	receivedAt := time.Now().UnixMilli()
+	point.ReceivedAt = &receivedAt
+	if a.isLowQuality(point) {
+		if a.config.lowQualityMode == "DROP" {
+			metricPointsRejected.WithLabelValues("low_quality").Inc()
+			a.markDeviceSeen(point.DeviceID)
+			a.debugf("Dropped low-quality fix from %s: HDOP %s, Lat %f, Lon %f, TS %d", point.DeviceID, formatHDOP(point.Accuracy), point.Latitude, point.Longitude, point.Timestamp)
+			return point, false, nil
+		}
+		point.LowQuality = true
+	}
+	if a.isDuplicate(point) {
+		metricPointsDeduplicated.Inc()
+		a.markDeviceSeen(point.DeviceID)
+		if a.config.dedupeBroadcast {
+			a.hub.send(hubMessage{Type: "still_here", Payload: stillHereMessage{DeviceID: point.DeviceID, Timestamp: point.Timestamp}, deviceID: point.DeviceID})
+		}
+		return point, false, nil
+	}
+	a.deriveBearing(&point)
+	if a.batch != nil {
+		if err := a.batch.add(point); err != nil {
+			return point, false, err
+		}
+	} else {
+		if a.insertLocationStmt == nil {
+			return point, false, errors.New("insert statement not prepared")
+		}
+		ctx, cancel := a.dbContext(ctx)
+		defer cancel()
+		err := a.retryOnBusy(ctx, func() error { return insertLocation(ctx, a.insertLocationStmt, point) })
+		// A canceled request says nothing about the database
+		if !errors.Is(err, context.Canceled) {
+			a.writeHealth.record(err)
+		}
+		if err != nil {
+			return point, false, err
+		}
+	}
+	a.rememberStored(point)
+	metricPointsReceived.Inc()
+	log.Printf("Received location from %s: Lat %f, Lon %f, TS %d", point.DeviceID, point.Latitude, point.Longitude, point.Timestamp)
+	return point, true, nil
+}
+
+// Check geofences and trips of a stored point and broadcast it, without blocking on slow consumers
+func (a *app) announceLocation(point locationPoint) {
+	// An inaccurate fix would cause spurious geofence transitions
+	if !point.LowQuality {
+		a.checkGeofences(point)
+	}
+	a.markDeviceSeen(point.DeviceID)
+	if a.config.resetOnTrip && a.startsTrip(point) {
+		log.Printf("Device %s started a new trip", point.DeviceID)
+		a.hub.broadcastControl(resetMessage{Type: "reset", Reason: "trip", DeviceID: point.DeviceID})
+	}
+	a.hub.publish(point)
+	a.pointHook.publish(point)
 }
 
 // Basic authentication middleware for HTTP handlers
 func (a *app) basicAuth(handler http.HandlerFunc, username, password, realm string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, pass, ok := r.BasicAuth()
-		if !ok || user != username || pass != password {
+		// Compare both values in constant time, also when the user name is already wrong
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		if !ok || !userOK || !passOK {
 			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
@@ -345,16 +1663,55 @@ func (a *app) basicAuth(handler http.HandlerFunc, username, password, realm stri
 
 func (a *app) wsHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle WebSocket upgrade and incoming messages
-	conn, err := websocket.Accept(w, r, nil)
+	opts := &websocket.AcceptOptions{}
+	if a.config.wsCompression {
+		// Small live updates stay uncompressed, large history payloads are compressed
+		// if the client supports it, otherwise messages are sent uncompressed
+		opts.CompressionMode = websocket.CompressionNoContextTakeover
+	}
+	// Clients may send a stable ID to get their subscription back after reconnecting
+	clientID := queryParam(r.URL.Query(), "clientId", "client_id")
+	if len(clientID) > maxClientIDLength {
+		http.Error(w, "Invalid clientId", http.StatusBadRequest)
+		return
+	}
+	state := clientState{clientID: clientID, remoteAddr: a.clientIP(r)}
+	restored, ok := a.hub.sessions.restore(clientID)
+	if ok {
+		state.devices = deviceSet(restored)
+	}
+	if !a.hub.acquireSlot() {
+		log.Printf("Rejecting WebSocket client from %s, maximum of %d clients reached", a.clientIP(r), a.hub.maxClients)
+		http.Error(w, "Too many WebSocket clients", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := websocket.Accept(w, r, opts)
 	if err != nil {
+		a.hub.releaseSlot()
 		log.Printf("Error upgrading to WebSocket: %v", err)
 		return
 	}
-	a.hub.register <- conn
+	state.connectedAt = time.Now()
+	select {
+	case a.hub.register <- clientRegistration{conn: conn, state: state}:
+	case <-a.hub.done:
+		a.hub.releaseSlot()
+		conn.Close(websocket.StatusGoingAway, "server shutting down")
+		return
+	}
+	if ok {
+		log.Printf("Restored WebSocket subscription to devices: %v", restored)
+	}
+	a.sendMeta(conn, restored, ok)
+
+	// Ping loop stops when the read goroutine exits
+	ctx, cancel := context.WithCancel(context.Background())
+	go a.pingClient(ctx, conn)
 
 	go func(c *websocket.Conn) {
 		defer func() {
-			a.hub.unregister <- c
+			cancel()
+			a.hub.unregisterClient(c)
 		}()
 		for {
 			_, p, err := c.Read(context.Background())
@@ -366,112 +1723,410 @@ func (a *app) wsHandler(w http.ResponseWriter, r *http.Request) {
 				}
 				break
 			}
-			var msg map[string]string
-			if err := json.Unmarshal(p, &msg); err == nil {
-				if msgType, ok := msg["type"]; ok && msgType == "get_history" {
-					a.sendHistoricalData(c)
+			var msg wsClientMessage
+			if err := json.Unmarshal(p, &msg); err != nil {
+				a.sendError(c, "invalid message: "+err.Error())
+				continue
+			}
+			switch msg.Type {
+			case "get_history":
+				windows := msg.Windows
+				if len(windows) == 0 {
+					windows = []historyWindow{msg.historyWindow}
+				} else if len(windows) > maxHistoryWindows {
+					a.sendError(c, fmt.Sprintf("too many history windows, at most %d are allowed", maxHistoryWindows))
+					continue
+				}
+				box := boundingBoxFromMessage(msg)
+				for _, window := range windows {
+					a.sendHistoricalData(ctx, c, window.Label, a.historySecondsFromMessage(window.Seconds), window.SinceTimestamp, box, downsampleOptionsFromWindow(window))
 				}
+			case "subscribe":
+				a.hub.subscribe(c, msg.Devices)
+			default:
+				a.sendError(c, fmt.Sprintf("unknown message type %q", msg.Type))
 			}
 		}
 	}(conn)
 }
 
-func (a *app) sendHistoricalData(conn *websocket.Conn) {
-	// Send historical location data (last 3 hours) to a WebSocket client
-	rows, err := a.db.Query("SELECT latitude, longitude, timestamp, altitude, speed, bearing, accuracy_hdop FROM locations WHERE (timestamp / 1000) >= (unixepoch() - 10800) ORDER BY timestamp ASC")
+// Helper to get the bounding box from a WebSocket message, incomplete or invalid boxes are ignored
+func boundingBoxFromMessage(msg wsClientMessage) *[4]float64 {
+	if msg.MinLat == nil || msg.MaxLat == nil || msg.MinLon == nil || msg.MaxLon == nil {
+		return nil
+	}
+	box := [4]float64{*msg.MinLat, *msg.MaxLat, *msg.MinLon, *msg.MaxLon}
+	if !validBoundingBox(box) {
+		return nil
+	}
+	return &box
+}
+
+// Message telling a WebSocket client why its message was not understood
+type errorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Helper to answer a WebSocket message that couldn't be handled
+func (a *app) sendError(conn *websocket.Conn, message string) {
+	msgBytes, err := json.Marshal(errorMessage{Type: "error", Message: message})
+	if err != nil {
+		log.Printf("Error marshalling error message: %v", err)
+		return
+	}
+	if err := a.hub.write(conn, msgBytes); err != nil {
+		log.Printf("Error sending error message to client: %v", err)
+	}
+}
+
+func (a *app) sendMeta(conn *websocket.Conn, subscription []string, restored bool) {
+	// Tell a newly registered WebSocket client the units of all values, the device status
+	// and the subscription restored from a previous connection
+	msgBytes, err := json.Marshal(metaMessage{Type: "meta", Units: canonicalUnits, Devices: a.deviceStatus.snapshot(), Restored: restored, Subscription: subscription})
 	if err != nil {
-		log.Printf("Error fetching historical data: %v", err)
+		log.Printf("Error marshalling meta message: %v", err)
+		return
+	}
+	if err := a.hub.write(conn, msgBytes); err != nil {
+		log.Printf("Error sending meta message to client: %v", err)
+	}
+}
+
+func (a *app) pingClient(ctx context.Context, conn *websocket.Conn) {
+	// Periodically ping a WebSocket client and unregister it when it stops responding
+	interval := a.config.wsPingInterval
+	if interval <= 0 {
 		return
 	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("WebSocket ping failed: %v. Unregistering.", err)
+				// Skip the close handshake, the client is not responding anyway
+				conn.CloseNow()
+				a.hub.unregisterClient(conn)
+				return
+			}
+		}
+	}
+}
+
+// Helper to resolve the history window requested by a client, clamped to the configured maximum
+func (a *app) historySecondsFromMessage(raw json.RawMessage) int64 {
+	value := strings.Trim(string(raw), `" `)
+	if value == "" {
+		return a.config.historySeconds
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return a.config.historySeconds
+	}
+	return min(seconds, a.config.maxHistorySeconds)
+}
+
+// Column set used when reading location points from the database
+const locationColumns = "latitude, longitude, timestamp, altitude, speed, bearing, accuracy_hdop, device_id, bearing_derived, battery, satellites, low_quality, label, " +
+	"CAST(strftime('%s', received_at) AS INTEGER) * 1000"
+
+// Helper to scan a row selected with locationColumns into a location point
+func scanLocation(row interface{ Scan(dest ...any) error }) (locationPoint, error) {
+	var p locationPoint
+	err := row.Scan(&p.Latitude, &p.Longitude, &p.Timestamp, &p.Altitude, &p.Speed, &p.Bearing, &p.Accuracy, &p.DeviceID, &p.BearingDerived, &p.Battery, &p.Satellites, &p.LowQuality, &p.Label, &p.ReceivedAt)
+	return p, err
+}
+
+// Helper to bound database calls made for a request by the configured timeout,
+// the returned context is also canceled together with its parent
+func (a *app) dbContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if a.config.dbTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, a.config.dbTimeout)
+}
+
+// Query location points ordered by timestamp, from and to are inclusive and after is an exclusive
+// Unix millisecond bound, each ignored when zero, limit is ignored when not positive
+func (a *app) queryLocations(ctx context.Context, from, after, to int64, box *[4]float64, limit int) ([]locationPoint, error) {
+	query := "SELECT " + locationColumns + " FROM locations WHERE 1=1"
+	var args []any
+	if from > 0 {
+		query += " AND timestamp >= ?"
+		args = append(args, from)
+	}
+	if after > 0 {
+		query += " AND timestamp > ?"
+		args = append(args, after)
+	}
+	if to > 0 {
+		query += " AND timestamp <= ?"
+		args = append(args, to)
+	}
+	if box != nil {
+		query += " AND latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?"
+		args = append(args, box[0], box[1], box[2], box[3])
+	}
+	query += " ORDER BY timestamp ASC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	ctx, cancel := a.dbContext(ctx)
+	defer cancel()
+	rows, err := a.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var history []locationPoint
+	points := []locationPoint{}
 	for rows.Next() {
-		var p locationPoint
-		err := rows.Scan(&p.Latitude, &p.Longitude, &p.Timestamp, &p.Altitude, &p.Speed, &p.Bearing, &p.Accuracy)
+		p, err := scanLocation(rows)
 		if err != nil {
-			log.Printf("Error scanning historical row: %v", err)
+			log.Printf("Error scanning location row: %v", err)
 			continue
 		}
-		history = append(history, p)
+		points = append(points, p)
 	}
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating historical rows: %v", err)
-		return
+	return points, rows.Err()
+}
+
+func (a *app) sendHistoricalData(ctx context.Context, conn *websocket.Conn, label string, seconds, after int64, box *[4]float64, opts downsampleOptions) {
+	// Send historical location data of the last seconds, optionally within a bounding box and only newer than
+	// after, to a WebSocket client, tagged with the label of the requested window, ctx is canceled when the
+	// connection ends
+	since := time.Now().Add(-time.Duration(seconds) * time.Second).UnixMilli()
+	// Short windows are usually covered by the recently published points, timestamps are whole
+	// milliseconds so the first one after the cursor is the inclusive bound of the buffer
+	history, ok := a.hub.recent.since(max(since, after+1), box)
+	if !ok {
+		var err error
+		history, err = a.queryLocations(ctx, since, after, 0, box, 0)
+		if err != nil {
+			log.Printf("Error fetching historical data: %v", err)
+			return
+		}
 	}
 
-	msgBytes, err := json.Marshal(map[string]any{"type": "history", "payload": history})
-	if err != nil {
-		log.Printf("Error marshalling historical data: %v", err)
+	// Snapshot the subscription so the writes don't hold the hub mutex
+	a.hub.mutex.Lock()
+	state, ok := a.hub.clients[conn]
+	a.hub.mutex.Unlock()
+	if !ok {
 		return
 	}
+	// Only send history of subscribed devices
+	history = slices.DeleteFunc(history, func(p locationPoint) bool { return !state.wants(p.DeviceID) })
+	history = downsample(history, opts)
 
-	a.hub.mutex.Lock()
-	defer a.hub.mutex.Unlock()
-	if _, ok := a.hub.clients[conn]; ok {
-		err = conn.Write(context.Background(), websocket.MessageText, msgBytes)
+	// Send the history in ascending chunks, an empty history is a single empty last chunk
+	chunkSize := int(a.config.historyChunkSize)
+	chunk := 0
+	for {
+		n := min(chunkSize, len(history))
+		msgBytes, err := json.Marshal(historyMessage{Type: "history", Window: label, Chunk: chunk, Last: n == len(history), Payload: history[:n]})
 		if err != nil {
+			log.Printf("Error marshalling historical data: %v", err)
+			return
+		}
+		if err := a.hub.write(conn, msgBytes); err != nil {
 			log.Printf("Error sending historical data to client: %v", err)
-		} else {
-			log.Printf("Sent %d historical points to client", len(history))
+			return
+		}
+		history = history[n:]
+		chunk++
+		if len(history) == 0 {
+			break
 		}
 	}
+	log.Printf("Sent historical points to client in %d chunks", chunk)
 }
 
-func main() {
-	// Application entry point
-	app := &app{
-		hub: &websocketHub{
-			clients:    make(map[*websocket.Conn]bool),
-			broadcast:  make(chan locationPoint),
-			register:   make(chan *websocket.Conn),
-			unregister: make(chan *websocket.Conn),
-		},
+// Helper to choose the files of the web interface: the configured directory when it exists, so the
+// interface can be customized without rebuilding, the embedded files otherwise
+func (a *app) staticFS() fs.FS {
+	if a.config.staticDir != "" {
+		if info, err := os.Stat(a.config.staticDir); err == nil && info.IsDir() {
+			log.Printf("Serving web interface from %s", a.config.staticDir)
+			return os.DirFS(a.config.staticDir)
+		}
+		log.Printf("Static directory %s not found, serving embedded web interface", a.config.staticDir)
 	}
-	app.loadConfig()
-	app.initDB()
-	go app.hub.run()
+	staticSubFs, _ := fs.Sub(staticFiles, "static")
+	return staticSubFs
+}
 
+func (a *app) routes() http.Handler {
 	// Set up HTTP routes and handlers
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /track", app.trackHandler)
-	mux.HandleFunc("GET /ws", app.basicAuth(app.wsHandler, app.config.user, app.config.pass, appName))
-	staticSubFs, _ := fs.Sub(staticFiles, "static")
-	mux.Handle("GET /", app.basicAuth(http.FileServer(http.FS(staticSubFs)).ServeHTTP, app.config.user, app.config.pass, appName))
+	mux.HandleFunc("GET /track", a.rateLimit(a.trackHandler))
+	mux.HandleFunc("POST /track", a.rateLimit(a.trackPostHandler))
+	mux.HandleFunc("POST /owntracks", a.rateLimit(a.ownTracksHandler))
+	mux.HandleFunc("GET /health", a.healthHandler)
+	// Live views are also available with the read-only share token
+	mux.HandleFunc("GET /ws", a.wsAuth(a.wsHandler))
+	mux.HandleFunc("GET /events", a.viewAuth(a.eventsHandler))
+	mux.HandleFunc("GET /api/config", a.viewAuth(a.configHandler))
+	mux.HandleFunc("GET /api/last", a.viewAuth(a.lastLocationHandler))
+	mux.HandleFunc("GET /api/place", a.viewAuth(a.placeHandler))
+
+	// API routes are authenticated and CORS-enabled, preflight requests skip authentication
+	apiRoute := func(method, path string, handler http.HandlerFunc) {
+		mux.HandleFunc(method+" "+path, a.cors(method, a.basicAuth(handler, a.config.user, a.config.pass, a.config.appName)))
+		mux.HandleFunc("OPTIONS "+path, a.cors(method, handler))
+	}
+	apiRoute("GET", "/api/history", a.gzip(a.historyHandler))
+	apiRoute("GET", "/api/stats", a.statsHandler)
+	apiRoute("GET", "/api/trips", a.tripsHandler)
+	apiRoute("GET", "/api/around", a.aroundHandler)
+	apiRoute("GET", "/api/lag", a.lagHandler)
+	apiRoute("GET", "/api/dbinfo", a.dbInfoHandler)
+	apiRoute("GET", "/api/devices", a.devicesHandler)
+	apiRoute("GET", "/api/clients", a.clientsHandler)
+	apiRoute("GET", "/api/migrations", a.migrationsHandler)
+	apiRoute("POST", "/api/token/rotate", a.rotateTokenHandler)
+	apiRoute("DELETE", "/api/locations", a.deleteLocationsHandler)
+	apiRoute("GET", "/export/gpx", a.gzip(a.exportGPXHandler))
+	apiRoute("GET", "/export/geojson", a.gzip(a.exportGeoJSONHandler))
+	apiRoute("GET", "/export/csv", a.gzip(a.exportCSVHandler))
+	apiRoute("GET", "/export/kml", a.gzip(a.exportKMLHandler))
+	mux.HandleFunc("POST /import/gpx", a.basicAuth(a.importGPXHandler, a.config.user, a.config.pass, a.config.appName))
+
+	if a.config.metricsAuth {
+		mux.HandleFunc("GET /metrics", a.basicAuth(promhttp.Handler().ServeHTTP, a.config.user, a.config.pass, a.config.appName))
+	} else {
+		mux.Handle("GET /metrics", promhttp.Handler())
+	}
+	if a.config.pprof {
+		a.registerPprof(mux)
+	}
+	mux.Handle("GET /", a.viewAuth(http.FileServer(http.FS(a.staticFS())).ServeHTTP))
 
-	srv := &http.Server{
-		Addr:    ":" + app.config.port,
-		Handler: mux,
+	if a.config.basePath == "" {
+		return a.accessLog(mux)
 	}
+	// Serve all routes below the base path, the bare base path redirects to the trailing slash
+	prefixed := http.NewServeMux()
+	prefixed.Handle(a.config.basePath+"/", http.StripPrefix(a.config.basePath, mux))
+	return a.accessLog(prefixed)
+}
+
+func main() {
+	// Application entry point
+	// One-shot maintenance flag, unlike an environment variable it can't stay set and roll back on every restart
+	migrateDown := flag.Bool("migrate-down", false, "roll back the last applied database migration and exit")
+	flag.Parse()
+	app := &app{}
+	app.loadConfig()
+	if *migrateDown {
+		// One-off maintenance run, the next normal start applies pending migrations again
+		app.openDB()
+		id, err := app.rollbackLastMigration()
+		app.db.Close()
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		log.Printf("Rolled back migration %s, exiting", id)
+		return
+	}
+	app.hub = newWebsocketHub(app.config.wsWriteTimeout)
+	app.hub.maxClients = int(app.config.maxWSClients)
+	app.hub.reconnectJitter = app.config.reconnectJitter
+	if app.config.recentBuffer > 0 {
+		app.hub.recent = newRecentBuffer(int(app.config.recentBuffer))
+	}
+	if app.config.wsSessionTTL > 0 {
+		app.hub.sessions = newSessionStore(app.config.wsSessionTTL)
+		app.goBackground(func() { app.hub.sessions.run(app.hub.done) })
+	}
+	if app.config.onPointCmd != "" {
+		app.pointHook = newPointHook(app.config.onPointCmd, app.config.onPointTimeout)
+		go app.pointHook.run()
+		log.Printf("Running %q for every stored location", app.config.onPointCmd)
+	}
+	if app.config.webhookURL != "" {
+		app.webhook = newWebhookSender(app.config.webhookURL)
+		go app.webhook.run()
+	}
+	if app.config.mqttURL != "" {
+		app.hub.mqtt = app.newMQTTPublisher()
+		go app.hub.mqtt.run()
+		log.Printf("Publishing locations to MQTT topic %s/<device> on %s", app.config.mqttTopic, app.config.mqttURL)
+	}
+	if app.config.geocodeURL != "" {
+		app.geocoder = newGeocoder(app.config.geocodeURL, app.config.appName+" (+https://git.jlel.se/jlelse/LiveTracker)")
+	}
+	app.initDB()
+	app.startBatchWriter()
+	if app.config.rateLimit > 0 {
+		app.limiter = newIPRateLimiter(app.config.rateLimit, int(max(app.config.rateBurst, 1)))
+		app.goBackground(func() { app.limiter.runCleanup(app.hub.done) })
+		log.Printf("Rate limiting enabled: %v requests/s per IP, burst %d", app.config.rateLimit, app.config.rateBurst)
+	}
+	go app.hub.run()
+	app.goBackground(app.runRetention)
+	app.goBackground(app.runStatusChecker)
+	app.goBackground(app.runCheckpoints)
+	if app.config.demo {
+		log.Printf("WARNING: DEMO MODE ENABLED, storing a synthetic location of device %q every %s", demoDeviceID, app.config.demoInterval)
+		log.Printf("WARNING: Disable LIVETRACKER_DEMO before tracking real devices")
+		app.goBackground(func() { app.runDemo(app.hub.done) })
+	}
+	if app.config.tokensFile != "" {
+		reloadCh := make(chan os.Signal, 1)
+		signal.Notify(reloadCh, syscall.SIGHUP)
+		go app.runTokensReloader(reloadCh)
+	}
+
+	srv := app.newServer()
 
 	// Graceful shutdown handling
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, os.Interrupt, syscall.SIGTERM)
 
+	shutdownDone := make(chan struct{})
 	go func() {
 		<-shutdownCh
 		log.Println("Shutdown signal received, shutting down server...")
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		srv.Shutdown(ctx)
-		if app.insertLocationStmt != nil {
-			app.insertLocationStmt.Close()
-		}
-		if app.db != nil {
-			app.db.Close()
-		}
-		os.Exit(0)
+		app.shutdown(srv)
+		close(shutdownDone)
 	}()
 
 	// Print startup information
-	log.Printf("Server starting on port %s", app.config.port)
-	log.Printf("OsmAnd URL: http://<your_ip>:%s/track?token=%s&lat={0}&lon={1}&timestamp={2}&hdop={3}&altitude={4}&speed={5}&bearing={6}", app.config.port, app.config.token)
-	log.Printf("Web interface: http://<your_ip>:%s (User: %s, Pass: ***)", app.config.port, app.config.user)
+	useTLS := app.config.tlsCert != "" && app.config.tlsKey != ""
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+		log.Printf("%s starting on %s with TLS (cert: %s, key: %s)", app.config.appName, describeBindAddr(srv.Addr), app.config.tlsCert, app.config.tlsKey)
+	} else {
+		log.Printf("%s starting on %s", app.config.appName, describeBindAddr(srv.Addr))
+	}
+	log.Printf("OsmAnd URL: %s://<your_ip>:%s%s/track?token=%s&lat={0}&lon={1}&timestamp={2}&hdop={3}&altitude={4}&speed={5}&bearing={6}", scheme, app.config.port, app.config.basePath, app.config.token)
+	log.Printf("Web interface: %s://<your_ip>:%s%s/ (User: %s, Pass: ***)", scheme, app.config.port, app.config.basePath, app.config.user)
 	log.Printf("SQLite Path: %s", app.config.dbPath)
 
-	err := srv.ListenAndServe()
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS(app.config.tlsCert, app.config.tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	// The server stops accepting connections right away, wait for the shutdown to finish
+	<-shutdownDone
+	log.Println("Shutdown complete")
 }
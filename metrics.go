@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, registered once with the default registry
+var (
+	metricPointsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "livetracker_points_received_total",
+		Help: "Total number of location points received.",
+	})
+	metricPointsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "livetracker_points_rejected_total",
+		Help: "Total number of rejected tracking requests by reason.",
+	}, []string{"reason"})
+	metricPointsDeduplicated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "livetracker_points_deduplicated_total",
+		Help: "Total number of received points skipped as duplicates of the last stored point.",
+	})
+	metricWebSocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "livetracker_websocket_clients",
+		Help: "Number of currently connected WebSocket clients.",
+	})
+	metricBroadcastsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "livetracker_broadcasts_dropped_total",
+		Help: "Total number of live updates dropped because the broadcast queue was full.",
+	})
+	metricInsertDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "livetracker_db_insert_duration_seconds",
+		Help:    "Latency of location inserts into the database.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+)
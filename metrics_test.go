@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	// Test that tracking requests are reflected in the exposed metrics
+	a := setupTestApp(t)
+	defer a.db.Close()
+	track := httptest.NewServer(http.HandlerFunc(a.trackHandler))
+	defer track.Close()
+	http.Get(track.URL + "/track?token=wrong&lat=1&lon=2&timestamp=3")
+	http.Get(track.URL + "/track?token=" + a.config.token + "&lat=1&lon=2&timestamp=3")
+
+	metrics := httptest.NewServer(promhttp.Handler())
+	defer metrics.Close()
+	resp, err := http.Get(metrics.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, name := range []string{
+		"livetracker_points_received_total",
+		`livetracker_points_rejected_total{reason="token"}`,
+		"livetracker_websocket_clients",
+		"livetracker_db_insert_duration_seconds_count",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("Metric %s not found in output", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Roll back the most recently applied migration by running its down SQL and removing its
+// schema_migrations row in one transaction, returns the ID of the reverted migration
+func (a *app) rollbackLastMigration() (string, error) {
+	var id string
+	err := a.db.QueryRow("SELECT id FROM schema_migrations ORDER BY id DESC LIMIT 1;").Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New("no applied migrations to roll back")
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query last applied migration: %w", err)
+	}
+	var down string
+	found := false
+	for _, m := range migrations {
+		if m.id == id {
+			down, found = m.down, true
+			break
+		}
+	}
+	if !found {
+		return id, fmt.Errorf("migration %s is unknown to this version", id)
+	}
+	if down == "" {
+		return id, fmt.Errorf("migration %s can't be rolled back", id)
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return id, fmt.Errorf("failed to begin transaction for rollback of %s: %w", id, err)
+	}
+	if _, err := tx.Exec(down); err != nil {
+		tx.Rollback()
+		return id, fmt.Errorf("failed to roll back migration %s: %w", id, err)
+	}
+	if _, err := tx.Exec("DELETE FROM schema_migrations WHERE id = ?;", id); err != nil {
+		tx.Rollback()
+		return id, fmt.Errorf("failed to remove migration record %s: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return id, fmt.Errorf("failed to commit rollback of %s: %w", id, err)
+	}
+	log.Printf("Migration %s rolled back successfully.", id)
+	return id, nil
+}
+
+// Status of a migration known to this version
+type migrationStatus struct {
+	ID      string `json:"id"`
+	Applied bool   `json:"applied"`
+}
+
+// Response of the migrations endpoint, the schema version is the highest applied migration ID
+type migrationsResponse struct {
+	SchemaVersion string            `json:"schema_version"`
+	Migrations    []migrationStatus `json:"migrations"`
+}
+
+// Helper to compare the known migrations against the schema_migrations table
+func (a *app) migrationStatuses(ctx context.Context) (migrationsResponse, error) {
+	ctx, cancel := a.dbContext(ctx)
+	defer cancel()
+	rows, err := a.db.QueryContext(ctx, "SELECT id FROM schema_migrations ORDER BY id ASC;")
+	if err != nil {
+		return migrationsResponse{}, err
+	}
+	defer rows.Close()
+	result := migrationsResponse{Migrations: []migrationStatus{}}
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return migrationsResponse{}, err
+		}
+		applied[id] = true
+		result.SchemaVersion = id
+	}
+	if err := rows.Err(); err != nil {
+		return migrationsResponse{}, err
+	}
+	for _, m := range migrations {
+		result.Migrations = append(result.Migrations, migrationStatus{ID: m.id, Applied: applied[m.id]})
+	}
+	return result, nil
+}
+
+func (a *app) migrationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the applied and pending migrations and the current schema version
+	status, err := a.migrationStatuses(r.Context())
+	if err != nil {
+		log.Printf("Error querying migrations: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
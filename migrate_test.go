@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRollbackLastMigration(t *testing.T) {
+	// Test that migrations are rolled back newest first and applied again on the next start
+	a := setupTestApp(t)
+	defer func() { a.db.Close() }()
+
+	hasColumn := func(name string) bool {
+		var n int
+		a.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('locations') WHERE name = ?;", name).Scan(&n)
+		return n == 1
+	}
+	isApplied := func(id string) bool {
+		var n int
+		a.db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE id = ?;", id).Scan(&n)
+		return n == 1
+	}
+
+	newest := migrations[len(migrations)-1].id
+	id, err := a.rollbackLastMigration()
+	if err != nil || id != newest || isApplied(newest) {
+		t.Fatalf("Expected %s to be rolled back, got %s: %v", newest, id, err)
+	}
+	for {
+		id, err = a.rollbackLastMigration()
+		if err != nil {
+			break
+		}
+		if id == "006_add_battery_satellites" && (hasColumn("battery") || hasColumn("satellites")) {
+			t.Fatal("Expected battery and satellites columns to be dropped")
+		}
+	}
+	if id != "001_initial_schema" || !isApplied(id) {
+		t.Fatalf("Expected rollback to stop at the initial schema without down SQL, got %s: %v", id, err)
+	}
+	if hasColumn("device_id") {
+		t.Fatal("Expected device_id column to be dropped")
+	}
+
+	// A normal start applies the rolled back migrations again
+	a.db.Close()
+	a.initDB()
+	if !isApplied(newest) || !hasColumn("device_id") || !hasColumn("battery") {
+		t.Fatal("Expected migrations to be applied again")
+	}
+	if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, 1000, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert after re-migration failed: %v", err)
+	}
+}
+
+func TestMigrationsHandler(t *testing.T) {
+	// Test that /api/migrations reports applied and pending migrations and requires authentication
+	a := setupTestApp(t)
+	defer a.db.Close()
+	if _, err := a.rollbackLastMigration(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	srv := httptest.NewServer(a.routes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/migrations")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/migrations", nil)
+	req.SetBasicAuth(a.config.user, a.config.pass)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var status migrationsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(status.Migrations) != len(migrations) {
+		t.Fatalf("Expected %d migrations, got %+v", len(migrations), status)
+	}
+	last := len(migrations) - 1
+	if status.SchemaVersion != migrations[last-1].id || status.Migrations[last].Applied || !status.Migrations[last-1].Applied {
+		t.Fatalf("Expected newest migration to be pending, got %+v", status)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Capacity of the MQTT publish queue, points are dropped when the broker can't keep up
+const mqttQueueSize = 64
+
+// Maximum time to wait for the broker to accept a single message
+const mqttPublishTimeout = 5 * time.Second
+
+// Publishes stored location points to an MQTT broker without blocking the tracking requests
+type mqttPublisher struct {
+	topic  string
+	points chan locationPoint
+	done   chan struct{}
+	// Held for reading while a point is queued, so close never closes the channel under a sender
+	mutex  sync.RWMutex
+	closed bool
+	// Sends a payload to a topic, replaced in tests
+	send func(topic string, payload []byte) error
+	// Disconnects from the broker once the queue is drained, optional
+	disconnect func()
+}
+
+// Connect to the configured broker, the connection is established and re-established in the background
+func (a *app) newMQTTPublisher() *mqttPublisher {
+	opts := mqtt.NewClientOptions().
+		AddBroker(a.config.mqttURL).
+		SetClientID(a.config.mqttClientID).
+		SetUsername(a.config.mqttUser).
+		SetPassword(a.config.mqttPass).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(func(mqtt.Client) { log.Printf("Connected to MQTT broker %s", a.config.mqttURL) }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) { log.Printf("MQTT connection lost, reconnecting: %v", err) })
+	client := mqtt.NewClient(opts)
+	// With connect retry enabled the token only completes once connected, so don't wait for it
+	client.Connect()
+	retain := a.config.mqttRetain
+	return &mqttPublisher{
+		topic:  a.config.mqttTopic,
+		points: make(chan locationPoint, mqttQueueSize),
+		done:   make(chan struct{}),
+		send: func(topic string, payload []byte) error {
+			if !client.IsConnectionOpen() {
+				return errors.New("not connected")
+			}
+			token := client.Publish(topic, 0, retain, payload)
+			if !token.WaitTimeout(mqttPublishTimeout) {
+				return errors.New("publish timed out")
+			}
+			return token.Error()
+		},
+		disconnect: func() { client.Disconnect(250) },
+	}
+}
+
+// Queue a point for publishing, a no-op when MQTT is disabled or the publisher is closed
+func (m *mqttPublisher) publish(p locationPoint) {
+	if m == nil {
+		return
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.points <- p:
+	default:
+		log.Printf("MQTT queue full, dropping location of %s", p.DeviceID)
+	}
+}
+
+func (m *mqttPublisher) run() {
+	// Publish queued points as JSON to <topic>/<device> until the publisher is closed
+	defer close(m.done)
+	for p := range m.points {
+		payload, err := json.Marshal(p)
+		if err != nil {
+			log.Printf("Error marshalling MQTT location: %v", err)
+			continue
+		}
+		if err := m.send(m.topic+"/"+mqttTopicLevel(p.DeviceID), payload); err != nil {
+			log.Printf("Error publishing location of %s to MQTT: %v", p.DeviceID, err)
+		}
+	}
+}
+
+// Stop accepting points, wait until the queued ones are published and disconnect, a no-op when MQTT is disabled
+func (m *mqttPublisher) close() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.points)
+	}
+	m.mutex.Unlock()
+	<-m.done
+	if m.disconnect != nil {
+		m.disconnect()
+	}
+}
+
+// Helper to make a device ID usable as a single topic level, wildcards and separators are replaced
+func mqttTopicLevel(deviceID string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(deviceID)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMQTTPublisher(t *testing.T) {
+	// Test that published points are sent as JSON to a topic per device
+	type message struct {
+		topic   string
+		payload []byte
+	}
+	sent := make(chan message, 2)
+	m := &mqttPublisher{topic: "home/tracker", points: make(chan locationPoint, mqttQueueSize), done: make(chan struct{}), send: func(topic string, payload []byte) error {
+		sent <- message{topic, payload}
+		return nil
+	}}
+	go m.run()
+	defer m.close()
+	hub := newWebsocketHub(time.Second)
+	hub.mqtt = m
+
+	hub.publish(locationPoint{Latitude: 1, Longitude: 2, Timestamp: 1000, DeviceID: "phone"})
+	hub.publish(locationPoint{Latitude: 3, Longitude: 4, Timestamp: 2000, DeviceID: "car/+#"})
+	for _, want := range []message{{"home/tracker/phone", nil}, {"home/tracker/car___", nil}} {
+		select {
+		case msg := <-sent:
+			var p locationPoint
+			if msg.topic != want.topic || json.Unmarshal(msg.payload, &p) != nil || p.Timestamp == 0 {
+				t.Fatalf("Expected point on %s, got %s: %s", want.topic, msg.topic, msg.payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s", want.topic)
+		}
+	}
+}
+
+func TestMQTTPublisherDoesNotBlock(t *testing.T) {
+	// Test that an unavailable broker neither blocks publishing nor stops later points
+	unblock := make(chan struct{})
+	calls := make(chan struct{}, mqttQueueSize+2)
+	m := &mqttPublisher{topic: "tracker", points: make(chan locationPoint, mqttQueueSize), done: make(chan struct{}), send: func(string, []byte) error {
+		<-unblock
+		calls <- struct{}{}
+		return errors.New("not connected")
+	}}
+	go m.run()
+	defer m.close()
+
+	done := make(chan struct{})
+	go func() {
+		for range mqttQueueSize * 2 {
+			m.publish(locationPoint{DeviceID: "phone"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publishing blocked on a stuck broker")
+	}
+	close(unblock)
+	select {
+	case <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected queued points to be sent after the broker recovered")
+	}
+
+	var disabled *mqttPublisher
+	disabled.publish(locationPoint{DeviceID: "phone"})
+}
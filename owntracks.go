@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Location message as sent by the OwnTracks app in HTTP mode
+type ownTracksMessage struct {
+	Type      string   `json:"_type"`
+	Latitude  *float64 `json:"lat"`
+	Longitude *float64 `json:"lon"`
+	Timestamp *int64   `json:"tst"`
+	Altitude  *float64 `json:"alt"`
+	Velocity  *float64 `json:"vel"`
+	Course    *float64 `json:"cog"`
+	Accuracy  *float64 `json:"acc"`
+	Battery   *float64 `json:"batt"`
+}
+
+// Convert an OwnTracks location message to a location point
+func (m ownTracksMessage) toLocationPoint(deviceID string) locationPoint {
+	p := locationPoint{
+		Latitude:  *m.Latitude,
+		Longitude: *m.Longitude,
+		Timestamp: *m.Timestamp * 1000,
+		Altitude:  m.Altitude,
+		Bearing:   m.Course,
+		Accuracy:  m.Accuracy,
+		DeviceID:  deviceID,
+		Battery:   m.Battery,
+	}
+	if m.Velocity != nil {
+		// OwnTracks reports km/h, stored speed is m/s
+		speed := *m.Velocity / 3.6
+		p.Speed = &speed
+	}
+	return p
+}
+
+func (a *app) ownTracksHandler(w http.ResponseWriter, r *http.Request) {
+	// Handle location messages from OwnTracks, the basic auth password is the device token
+	_, token, _ := r.BasicAuth()
+	deviceID, ok := a.deviceForToken(token)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+a.config.appName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		metricPointsRejected.WithLabelValues("token").Inc()
+		log.Printf("Unauthorized OwnTracks request from %s", a.clientIP(r))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.config.maxTrackBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var msg ownTracksMessage
+	json.Unmarshal(fields["_type"], &msg.Type)
+
+	// Other message types (waypoints, transitions, ...) are acknowledged but not stored
+	var stored *locationPoint
+	if msg.Type == "location" {
+		// The app sends many more fields than are stored, unknown ones are only rejected in strict mode
+		if problems := checkBodyFields[ownTracksMessage](fields, []string{"lat", "lon", "tst"}, a.config.ownTracksStrictJSON); len(problems) > 0 {
+			writeBodyProblems(w, problems)
+			return
+		}
+		// All fields decoded on their own, so the message does as well
+		json.Unmarshal(body, &msg)
+		point := msg.toLocationPoint(deviceID)
+		if err := a.validateLocation(point); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			metricPointsRejected.WithLabelValues("invalid").Inc()
+			return
+		}
+		point, ok, err := a.insertPoint(r.Context(), point)
+		if err != nil {
+			log.Printf("Error saving OwnTracks location: %v", err)
+			writeStoreError(w, err)
+			return
+		}
+		if ok {
+			stored = &point
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("[]"))
+	// The app gets its response before the point is broadcast, so a slow broadcast doesn't make it resend
+	if stored != nil {
+		http.NewResponseController(w).Flush()
+		a.announceLocation(*stored)
+	}
+}
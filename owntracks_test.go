@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOwnTracksHandler(t *testing.T) {
+	// Test that OwnTracks location messages are mapped and stored
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.devices = map[string]string{"phonetoken": "phone"}
+	ts := httptest.NewServer(http.HandlerFunc(a.ownTracksHandler))
+	defer ts.Close()
+
+	post := func(pass, body string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/owntracks", strings.NewReader(body))
+		req.SetBasicAuth("user", pass)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	msg := `{"_type":"location","lat":50.1,"lon":8.6,"tst":1680000000,"alt":120,"vel":36,"cog":90,"acc":5}`
+	if status, body := post("phonetoken", msg); status != http.StatusOK || body != "[]" {
+		t.Fatalf("Expected 200 with [], got %d %s", status, body)
+	}
+	if status, _ := post("wrong", msg); status != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", status)
+	}
+	if status, _ := post("phonetoken", `{"_type":"lwt","tst":1680000000}`); status != http.StatusOK {
+		t.Fatalf("Expected 200 for non-location message, got %d", status)
+	}
+
+	var ts2 int64
+	var speed, bearing, acc float64
+	var device string
+	err := a.db.QueryRow("SELECT timestamp, speed, bearing, accuracy_hdop, device_id FROM locations;").Scan(&ts2, &speed, &bearing, &acc, &device)
+	if err != nil {
+		t.Fatalf("Row not found: %v", err)
+	}
+	if ts2 != 1680000000000 || speed != 10 || bearing != 90 || acc != 5 || device != "phone" {
+		t.Fatalf("Unexpected values: %d %v %v %v %s", ts2, speed, bearing, acc, device)
+	}
+}
+
+func TestOwnTracksHandlerRespondsBeforeBroadcast(t *testing.T) {
+	// Test that the app gets its response right after the insert even while broadcasting the point is stuck
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.devices = map[string]string{"phonetoken": "phone"}
+	a.hub.recent = newRecentBuffer(10)
+	ts := httptest.NewServer(http.HandlerFunc(a.ownTracksHandler))
+	defer ts.Close()
+
+	// Holding the recent buffer mutex wedges publishing the point before it reaches the hub
+	a.hub.recent.mutex.Lock()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/owntracks", strings.NewReader(`{"_type":"location","lat":50.1,"lon":8.6,"tst":1680000000}`))
+	req.SetBasicAuth("user", "phonetoken")
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		a.hub.recent.mutex.Unlock()
+		t.Fatalf("Expected a response while the broadcast is stuck: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.hub.recent.mutex.Unlock()
+	if resp.StatusCode != http.StatusOK || string(body) != "[]" {
+		t.Fatalf("Expected 200 with [], got %d: %s", resp.StatusCode, body)
+	}
+}
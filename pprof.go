@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// Mount the pprof handlers under /debug/pprof/, all of them require basic authentication
+func (a *app) registerPprof(mux *http.ServeMux) {
+	auth := func(handler http.HandlerFunc) http.HandlerFunc {
+		return a.basicAuth(handler, a.config.user, a.config.pass, a.config.appName)
+	}
+	// The index also serves the named profiles like heap and goroutine
+	mux.HandleFunc("GET /debug/pprof/", auth(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", auth(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", auth(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", auth(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", auth(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", auth(pprof.Trace))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprofRoutes(t *testing.T) {
+	// Test that pprof is only served when enabled and then requires authentication
+	a := setupTestApp(t)
+	defer a.db.Close()
+
+	get := func(srv *httptest.Server, path string, auth bool) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if auth {
+			req.SetBasicAuth(a.config.user, a.config.pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	srv := httptest.NewServer(a.routes())
+	if status := get(srv, "/debug/pprof/goroutine", true); status == http.StatusOK {
+		t.Fatalf("Expected pprof to be disabled by default, got %d", status)
+	}
+	srv.Close()
+
+	a.config.pprof = true
+	srv = httptest.NewServer(a.routes())
+	defer srv.Close()
+	if status := get(srv, "/debug/pprof/goroutine", false); status != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %d", status)
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/heap", "/debug/pprof/cmdline"} {
+		if status := get(srv, path, true); status != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", path, status)
+		}
+	}
+}
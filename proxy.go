@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Helper to parse a comma-separated list of CIDRs, e.g. of trusted proxies, plain IPs are treated as single hosts
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Check whether an IP belongs to a trusted proxy
+func (a *app) isTrustedProxy(ip net.IP) bool {
+	for _, network := range a.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Determine the client IP of a request. Forwarding headers are only honored when the
+// direct peer is a trusted proxy, so clients can't spoof their address.
+func (a *app) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if peerIP := net.ParseIP(peer); peerIP == nil || !a.isTrustedProxy(peerIP) {
+		return peer
+	}
+
+	// Walk X-Forwarded-For from the right, the first untrusted address is the client
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for entry := range strings.SplitSeq(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(entry))
+		}
+	}
+	client := ""
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !a.isTrustedProxy(ip) {
+			break
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	// Test that CIDRs and plain IPs are parsed and invalid entries are rejected
+	proxies, err := parseNetworks("10.0.0.0/8, 192.168.1.5,::1,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(proxies) != 3 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "192.168.1.5/32" || proxies[2].String() != "::1/128" {
+		t.Fatalf("Unexpected proxies: %v", proxies)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := parseNetworks(invalid); err == nil {
+			t.Fatalf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	// Test that forwarding headers are only honored from trusted proxies
+	a := &app{}
+	a.config.trustedProxies, _ = parseNetworks("10.0.0.0/8,::1")
+
+	for _, tc := range []struct {
+		name, remoteAddr, forwarded, realIP, expected string
+	}{
+		{"untrusted peer without headers", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"untrusted peer with forged forwarded header", "203.0.113.7:1234", "1.2.3.4", "", "203.0.113.7"},
+		{"untrusted peer with forged real IP header", "203.0.113.7:1234", "", "1.2.3.4", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted IPv6 proxy", "[::1]:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted proxy chain", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"spoofed entry left of the real client", "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"only trusted entries", "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"invalid forwarded entry", "10.0.0.1:1234", "garbage", "198.51.100.2", "198.51.100.2"},
+		{"trusted proxy with real IP header", "10.0.0.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"trusted proxy without headers", "10.0.0.1:1234", "", "", "10.0.0.1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/track", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := a.clientIP(req); got != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Time after which an idle client's bucket is removed
+const rateLimitIdleTimeout = 5 * time.Minute
+
+// Per-IP token bucket rate limiter
+type ipRateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	mutex   sync.Mutex
+	clients map[string]*rateLimitClient
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPRateLimiter(limit float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		clients: make(map[string]*rateLimitClient),
+	}
+}
+
+// Reserve a token for the IP, returns how long to wait if the request is over the limit
+func (l *ipRateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &rateLimitClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = time.Now()
+	reservation := client.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// Remove buckets of clients that have been idle for longer than maxIdle
+func (l *ipRateLimiter) cleanup(maxIdle time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for ip, client := range l.clients {
+		if time.Since(client.lastSeen) > maxIdle {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+func (l *ipRateLimiter) runCleanup(done <-chan struct{}) {
+	// Periodically drop idle buckets so memory doesn't grow with unique IPs, until the hub shuts down
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.cleanup(rateLimitIdleTimeout)
+		}
+	}
+}
+
+// Rate limiting middleware for tracking handlers, a no-op when rate limiting is disabled
+func (a *app) rateLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.limiter != nil {
+			if ok, wait := a.limiter.allow(a.clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				metricPointsRejected.WithLabelValues("rate_limit").Inc()
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
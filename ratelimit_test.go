@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	// Test that requests over the burst are rejected per IP with Retry-After
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.limiter = newIPRateLimiter(0.5, 2)
+	h := a.rateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(remoteAddr, forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/track", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	for range 2 {
+		if rec := do("10.0.0.1:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 within burst, got %d", rec.Code)
+		}
+	}
+	rec := do("10.0.0.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Expected 429 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("10.0.0.2:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for other IP, got %d", rec.Code)
+	}
+
+	// X-Forwarded-For is only honored behind a trusted proxy
+	if rec := do("10.0.0.1:1234", "192.168.1.1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 when proxy is not trusted, got %d", rec.Code)
+	}
+	a.config.trustedProxies, _ = parseNetworks("10.0.0.0/8")
+	if rec := do("10.0.0.1:1234", "192.168.1.1, 10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for forwarded IP, got %d", rec.Code)
+	}
+
+	a.limiter.cleanup(-time.Second)
+	if len(a.limiter.clients) != 0 {
+		t.Fatalf("Expected idle buckets to be removed, %d left", len(a.limiter.clients))
+	}
+}
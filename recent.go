@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// In-memory buffer of the most recently stored points ordered by timestamp, used to answer
+// short history requests without querying the database. It only knows points stored since
+// startup or the last reset, so it covers timestamps from coveredFrom on.
+type recentBuffer struct {
+	mutex       sync.Mutex
+	capacity    int
+	points      []locationPoint
+	coveredFrom int64
+}
+
+func newRecentBuffer(capacity int) *recentBuffer {
+	return &recentBuffer{capacity: capacity, coveredFrom: time.Now().UnixMilli()}
+}
+
+// Add a stored point, late points are inserted in timestamp order and the oldest point is evicted when full
+func (b *recentBuffer) add(p locationPoint) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if p.Timestamp < b.coveredFrom {
+		return
+	}
+	i := len(b.points)
+	for i > 0 && b.points[i-1].Timestamp > p.Timestamp {
+		i--
+	}
+	b.points = slices.Insert(b.points, i, p)
+	if len(b.points) > b.capacity {
+		// Points with the evicted timestamp may still be in the buffer, but not all of them
+		b.coveredFrom = b.points[0].Timestamp + 1
+		b.points = slices.Delete(b.points, 0, 1)
+	}
+}
+
+// Return the buffered points since the given Unix millisecond timestamp, optionally within a bounding box,
+// ok is false when the buffer doesn't cover the whole window
+func (b *recentBuffer) since(from int64, box *[4]float64) (points []locationPoint, ok bool) {
+	if b == nil {
+		return nil, false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if from < b.coveredFrom {
+		return nil, false
+	}
+	points = []locationPoint{}
+	for _, p := range b.points {
+		if p.Timestamp < from {
+			continue
+		}
+		if box != nil && (p.Latitude < box[0] || p.Latitude > box[1] || p.Longitude < box[2] || p.Longitude > box[3]) {
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, true
+}
+
+// Forget all buffered points after locations were deleted or written without a broadcast,
+// only points stored from now on are served from memory again
+func (b *recentBuffer) reset() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.points = nil
+	b.coveredFrom = time.Now().UnixMilli()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestRecentBuffer(t *testing.T) {
+	// Test ordering, eviction and coverage of the recent points buffer
+	b := &recentBuffer{capacity: 3, coveredFrom: 1000}
+	for _, ts := range []int64{1000, 3000, 2000, 500} {
+		b.add(locationPoint{Latitude: float64(ts) / 1000, Timestamp: ts})
+	}
+	points, ok := b.since(1000, nil)
+	if !ok || len(points) != 3 || points[0].Timestamp != 1000 || points[1].Timestamp != 2000 || points[2].Timestamp != 3000 {
+		t.Fatalf("Expected 3 ordered points, got %+v (%v)", points, ok)
+	}
+
+	b.add(locationPoint{Latitude: 4, Timestamp: 4000})
+	if _, ok := b.since(1000, nil); ok {
+		t.Fatal("Expected window with an evicted point not to be covered")
+	}
+	points, ok = b.since(2500, nil)
+	if !ok || len(points) != 2 || points[0].Timestamp != 3000 {
+		t.Fatalf("Expected 2 points since 2500, got %+v (%v)", points, ok)
+	}
+	points, _ = b.since(1001, &[4]float64{3.5, 5, -1, 1})
+	if len(points) != 1 || points[0].Timestamp != 4000 {
+		t.Fatalf("Expected 1 point within the bounding box, got %+v", points)
+	}
+
+	b.reset()
+	if _, ok := b.since(1001, nil); ok {
+		t.Fatal("Expected reset buffer not to cover old windows")
+	}
+
+	var disabled *recentBuffer
+	disabled.add(locationPoint{Timestamp: 1000})
+	if _, ok := disabled.since(0, nil); ok {
+		t.Fatal("Expected disabled buffer not to cover any window")
+	}
+}
+
+func TestSendHistoricalDataFromRecentBuffer(t *testing.T) {
+	// Test that get_history is served from memory when the buffer covers the window and from the database otherwise
+	a := setupTestApp(t)
+	defer a.db.Close()
+	now := time.Now().UnixMilli()
+	// As if the server had been started an hour ago
+	a.hub.recent = &recentBuffer{capacity: 10, coveredFrom: now - time.Hour.Milliseconds()}
+	a.config.maxHistorySeconds = 86400
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+
+	if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: 2, Timestamp: now, DeviceID: defaultDeviceID}); err != nil {
+		t.Fatalf("Storing location failed: %v", err)
+	}
+	// Only the buffer still knows the point afterwards
+	a.db.Exec("DELETE FROM locations")
+
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer c.Close()
+	expectMeta(t, c)
+
+	getHistory := func(seconds int) []locationPoint {
+		c.WriteJSON(map[string]any{"type": "get_history", "seconds": seconds})
+		var reply historyMessage
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := c.ReadJSON(&reply); err != nil || reply.Type != "history" {
+			t.Fatalf("Expected history, got %+v (%v)", reply, err)
+		}
+		return reply.Payload
+	}
+	if points := getHistory(60); len(points) != 1 || points[0].Latitude != 1 {
+		t.Fatalf("Expected the buffered point, got %+v", points)
+	}
+	if points := getHistory(7200); len(points) != 0 {
+		t.Fatalf("Expected window older than the buffer to come from the database, got %+v", points)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Number of rows deleted per statement when pruning old locations
+const retentionBatchSize = 1000
+
+// Interval between retention runs
+const retentionInterval = time.Hour
+
+func (a *app) runRetention() {
+	// Periodically prune locations older than the configured retention period until the hub shuts down
+	if a.config.retentionDays <= 0 {
+		return
+	}
+	log.Printf("Retention enabled: keeping %d day(s) of locations", a.config.retentionDays)
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().AddDate(0, 0, -int(a.config.retentionDays)).UnixMilli()
+		deleted, err := a.pruneLocations(cutoff)
+		if err != nil {
+			log.Printf("Error pruning old locations: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Retention removed %d location(s) older than %s", deleted, timestampToTime(cutoff).Format(time.RFC3339))
+			a.hub.recent.reset()
+			if a.config.retentionVacuum {
+				a.vacuum()
+			}
+		}
+		select {
+		case <-a.hub.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Delete locations older than the cutoff (Unix milliseconds) in batches
+func (a *app) pruneLocations(cutoff int64) (int64, error) {
+	var total int64
+	for {
+		res, err := a.db.Exec("DELETE FROM locations WHERE id IN (SELECT id FROM locations WHERE timestamp < ? LIMIT ?)", cutoff, retentionBatchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (a *app) vacuum() {
+	// Rebuild the database file so it shrinks after pruning
+	start := time.Now()
+	if _, err := a.db.Exec("VACUUM;"); err != nil {
+		log.Printf("Error vacuuming database: %v", err)
+		return
+	}
+	log.Printf("Database vacuumed in %s", time.Since(start))
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPruneLocations(t *testing.T) {
+	// Test that locations older than the cutoff are deleted across multiple batches
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for i := range retentionBatchSize + 5 {
+		if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, int64(i), defaultDeviceID, false, nil, nil, false, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if _, err := a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, 1_000_000, defaultDeviceID, false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	deleted, err := a.pruneLocations(500_000)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if deleted != retentionBatchSize+5 {
+		t.Fatalf("Expected %d deleted rows, got %d", retentionBatchSize+5, deleted)
+	}
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected 1 remaining row: %v, count=%d", err, count)
+	}
+	a.vacuum()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Check whether an error is a transient SQLite busy or locked error
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// Run fn and retry it with exponential backoff while it fails with busy or locked errors,
+// waiting for a retry stops early when ctx is done
+func (a *app) retryOnBusy(ctx context.Context, fn func() error) error {
+	backoff := a.config.insertRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isBusyError(err) || attempt >= int(a.config.insertAttempts) {
+			return err
+		}
+		log.Printf("Database busy (attempt %d of %d), retrying in %s: %v", attempt, a.config.insertAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestStoreLocationRetriesWhenLocked(t *testing.T) {
+	// Test that an insert into a locked database succeeds once the lock is released
+	a := setupTestApp(t)
+	a.db.Close()
+	// Without busy timeout, SQLite reports a locked database immediately
+	a.config.sqliteBusyTimeout = 0
+	a.config.insertAttempts = 10
+	a.config.insertRetryBackoff = 10 * time.Millisecond
+	a.initDB()
+	defer a.db.Close()
+
+	locker, err := sql.Open("sqlite3", a.config.dbPath)
+	if err != nil {
+		t.Fatalf("Opening second connection failed: %v", err)
+	}
+	defer locker.Close()
+	tx, err := locker.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO locations(latitude, longitude, timestamp) VALUES(0, 0, 0)"); err != nil {
+		t.Fatalf("Locking insert failed: %v", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tx.Rollback()
+	}()
+
+	if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: 2, Timestamp: 1000, DeviceID: defaultDeviceID}); err != nil {
+		t.Fatalf("Expected insert to succeed after retries, got %v", err)
+	}
+	var count int
+	a.db.QueryRow("SELECT COUNT(*) FROM locations").Scan(&count)
+	if count != 1 {
+		t.Fatalf("Expected 1 location, got %d", count)
+	}
+}
+
+func TestRetryOnBusy(t *testing.T) {
+	// Test that only busy and locked errors are retried, up to the configured attempts
+	a := &app{config: appConfig{insertAttempts: 3, insertRetryBackoff: time.Millisecond}}
+
+	calls := 0
+	err := a.retryOnBusy(context.Background(), func() error {
+		calls++
+		return errors.New("constraint failed")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("Expected non-busy error to fail fast, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = a.retryOnBusy(context.Background(), func() error {
+		calls++
+		return sqlite3.Error{Code: sqlite3.ErrLocked}
+	})
+	if !isBusyError(err) || calls != 3 {
+		t.Fatalf("Expected locked error after 3 calls, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = a.retryOnBusy(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Expected success on second call, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryOnBusyCanceled(t *testing.T) {
+	// Test that waiting for a retry stops once the context is done
+	a := &app{config: appConfig{insertAttempts: 10, insertRetryBackoff: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := a.retryOnBusy(ctx, func() error {
+		calls++
+		cancel()
+		return sqlite3.Error{Code: sqlite3.ErrBusy}
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("Expected canceled retry after 1 call, got %v after %d calls", err, calls)
+	}
+}
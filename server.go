@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Create the HTTP server with the configured timeouts. HTTP/2 is negotiated automatically with TLS,
+// unencrypted HTTP/2 (h2c) is only offered when enabled for proxies speaking it to the backend.
+func (a *app) newServer() *http.Server {
+	srv := &http.Server{
+		Addr:              net.JoinHostPort(a.config.bindAddr, a.config.port),
+		Handler:           a.routes(),
+		ReadHeaderTimeout: a.config.readHeaderTimeout,
+		ReadTimeout:       a.config.readTimeout,
+		WriteTimeout:      a.config.writeTimeout,
+		IdleTimeout:       a.config.idleTimeout,
+	}
+	if a.config.h2c {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// Stop the server and release all resources: WebSocket clients are told to reconnect and background
+// loops stop, in-flight requests get the shutdown timeout to finish, then pending points are written,
+// the point hook, webhook and MQTT queues are drained and the database is closed
+func (a *app) shutdown(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.shutdownTimeout)
+	defer cancel()
+	a.hub.shutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	a.background.Wait()
+	if a.batch != nil {
+		a.batch.close()
+	}
+	a.pointHook.close()
+	a.webhook.close()
+	a.hub.mqtt.close()
+	if a.db != nil {
+		if err := a.checkpointWAL(); err != nil {
+			log.Printf("Error checkpointing WAL: %v", err)
+		}
+	}
+	if a.insertLocationStmt != nil {
+		a.insertLocationStmt.Close()
+	}
+	if a.readDB != nil {
+		a.readDB.Close()
+	}
+	if a.db != nil {
+		a.db.Close()
+	}
+}
+
+// Helper to run a background loop that returns once the hub shuts down, shutdown waits for it
+func (a *app) goBackground(loop func()) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		loop()
+	}()
+}
+
+// Helper to lift the server read and write timeouts for long-lived responses like event streams and exports,
+// which end when the client disconnects. Hijacked WebSocket connections don't need it, net/http clears
+// their deadlines and the hub's pings and write timeouts detect dead clients.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	// Not supported by test recorders, where there are no deadlines to clear
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
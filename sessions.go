@@ -0,0 +1,95 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Maximum length of a client ID sent by a WebSocket client
+const maxClientIDLength = 64
+
+// Interval of removing expired sessions, shorter when the session TTL is shorter
+const sessionCleanupInterval = time.Minute
+
+// Device subscription of a WebSocket client, remembered by its client ID so a reconnecting client
+// gets it back without subscribing again
+type wsSession struct {
+	devices []string
+	expires time.Time
+}
+
+// Remembered subscriptions by client ID, each kept for a TTL after it was last saved
+type sessionStore struct {
+	mutex    sync.Mutex
+	ttl      time.Duration
+	sessions map[string]wsSession
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{ttl: ttl, sessions: make(map[string]wsSession)}
+}
+
+// Remember the subscribed devices of a client, an empty subscription (all devices) is the default
+// and only forgets the session. A no-op when sessions are disabled or the client sent no ID.
+func (s *sessionStore) save(clientID string, devices map[string]bool) {
+	if s == nil || clientID == "" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(devices) == 0 {
+		delete(s.sessions, clientID)
+		return
+	}
+	s.sessions[clientID] = wsSession{devices: slices.Sorted(maps.Keys(devices)), expires: time.Now().Add(s.ttl)}
+}
+
+// Return the subscribed devices remembered for a client, ok is false when there is no unexpired session
+func (s *sessionStore) restore(clientID string) (devices []string, ok bool) {
+	if s == nil || clientID == "" {
+		return nil, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[clientID]
+	if !ok || !time.Now().Before(session.expires) {
+		return nil, false
+	}
+	return session.devices, true
+}
+
+// Remove sessions that expired before now
+func (s *sessionStore) expire(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for clientID, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, clientID)
+		}
+	}
+}
+
+func (s *sessionStore) run(done <-chan struct{}) {
+	// Periodically remove expired sessions until the hub shuts down
+	ticker := time.NewTicker(min(sessionCleanupInterval, s.ttl))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+// Helper to turn a list of device IDs into a set
+func deviceSet(devices []string) map[string]bool {
+	set := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		set[device] = true
+	}
+	return set
+}
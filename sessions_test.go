@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestSessionStore(t *testing.T) {
+	// Test saving, restoring and expiring subscriptions by client ID
+	s := newSessionStore(time.Minute)
+	s.save("tab1", deviceSet([]string{"phone", "bike"}))
+	s.save("", deviceSet([]string{"phone"}))
+	if devices, ok := s.restore("tab1"); !ok || !slices.Equal(devices, []string{"bike", "phone"}) {
+		t.Fatalf("Expected restored subscription, got %v (%v)", devices, ok)
+	}
+	if _, ok := s.restore(""); ok {
+		t.Fatal("Expected no session without a client ID")
+	}
+
+	s.expire(time.Now().Add(30 * time.Second))
+	if _, ok := s.restore("tab1"); !ok {
+		t.Fatal("Expected session to survive before its TTL")
+	}
+	s.expire(time.Now().Add(2 * time.Minute))
+	if _, ok := s.restore("tab1"); ok {
+		t.Fatal("Expected session to expire after its TTL")
+	}
+
+	s.save("tab2", deviceSet([]string{"phone"}))
+	s.save("tab2", nil)
+	if _, ok := s.restore("tab2"); ok {
+		t.Fatal("Expected an empty subscription to forget the session")
+	}
+
+	var disabled *sessionStore
+	disabled.save("tab1", deviceSet([]string{"phone"}))
+	if _, ok := disabled.restore("tab1"); ok {
+		t.Fatal("Expected disabled sessions not to restore anything")
+	}
+}
+
+func TestWebSocketSubscriptionRestored(t *testing.T) {
+	// Test that a client reconnecting with the same client ID gets its subscription back
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.hub.sessions = newSessionStore(time.Minute)
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dial := func(query string) (*gwss.Conn, metaMessage) {
+		c, _, err := gwss.DefaultDialer.Dial(wsURL+"?"+query, nil)
+		if err != nil {
+			t.Fatalf("WebSocket dial failed: %v", err)
+		}
+		var meta metaMessage
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := c.ReadJSON(&meta); err != nil || meta.Type != "meta" {
+			t.Fatalf("Expected meta message, got %+v (%v)", meta, err)
+		}
+		return c, meta
+	}
+
+	c, meta := dial("clientId=tab1")
+	if meta.Restored {
+		t.Fatalf("Expected nothing to restore on the first connection, got %+v", meta)
+	}
+	c.WriteJSON(map[string]any{"type": "subscribe", "devices": []string{"bike"}})
+	time.Sleep(100 * time.Millisecond)
+	c.Close()
+
+	// The snake_case name refers to the same client ID
+	c, meta = dial("client_id=tab1")
+	defer c.Close()
+	if !meta.Restored || !slices.Equal(meta.Subscription, []string{"bike"}) {
+		t.Fatalf("Expected restored bike subscription, got %+v", meta)
+	}
+	time.Sleep(100 * time.Millisecond)
+	a.hub.publish(locationPoint{Latitude: 1, Longitude: 1, DeviceID: "phone"})
+	a.hub.publish(locationPoint{Latitude: 2, Longitude: 2, DeviceID: "bike"})
+	var reply struct {
+		Type    string        `json:"type"`
+		Payload locationPoint `json:"payload"`
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := c.ReadJSON(&reply); err != nil || reply.Type != "update" || reply.Payload.DeviceID != "bike" {
+		t.Fatalf("Expected bike update, got %+v (%v)", reply, err)
+	}
+
+	other, meta := dial("clientId=tab2")
+	defer other.Close()
+	if meta.Restored {
+		t.Fatalf("Expected no subscription for another client ID, got %+v", meta)
+	}
+	if _, resp, err := gwss.DefaultDialer.Dial(wsURL+"?clientId="+strings.Repeat("x", maxClientIDLength+1), nil); err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a too long client ID, got %v", err)
+	}
+}
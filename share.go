@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+)
+
+// Cookie remembering a valid share token, so assets and the WebSocket of the shared page load without it in the URL
+const shareCookieName = "livetracker_share"
+
+// Check whether the request carries the share token, as share query parameter or cookie
+func (a *app) hasShareToken(r *http.Request) bool {
+	if a.config.shareToken == "" {
+		return false
+	}
+	token := r.URL.Query().Get("share")
+	if token == "" {
+		if cookie, err := r.Cookie(shareCookieName); err == nil {
+			token = cookie.Value
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.config.shareToken)) == 1
+}
+
+// Check whether a token grants read-only access to the WebSocket: the share token, but never
+// a token that can send locations or the built-in default token
+func (a *app) isViewToken(token string) bool {
+	if token == "" || token == "default" || a.config.shareToken == "" {
+		return false
+	}
+	if _, ok := a.deviceForToken(token); ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.config.shareToken)) == 1
+}
+
+// Authentication middleware for the WebSocket, additionally accepts the share token as token
+// query parameter for clients that can't send basic authentication. Invalid query tokens are rejected
+// without a basic authentication challenge.
+func (a *app) wsAuth(handler http.HandlerFunc) http.HandlerFunc {
+	view := a.viewAuth(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Has("token"):
+			token := query.Get("token")
+			if !a.isViewToken(token) {
+				log.Printf("Unauthorized WebSocket access attempt with token %s from %s", redactToken(token), a.clientIP(r))
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			handler(w, r)
+		case query.Has("share") && !a.hasShareToken(r):
+			http.Error(w, "Invalid share token", http.StatusUnauthorized)
+		default:
+			view(w, r)
+		}
+	}
+}
+
+// Check whether a request comes from a network exempt from basic authentication. The client IP
+// only honors forwarding headers of trusted proxies, so the exemption can't be spoofed.
+func (a *app) skipsAuth(r *http.Request) bool {
+	if len(a.config.authSkipNetworks) == 0 {
+		return false
+	}
+	ip := net.ParseIP(a.clientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.config.authSkipNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authentication middleware for read-only views, accepts basic authentication or the share token
+// and lets clients from networks exempt from authentication through
+func (a *app) viewAuth(handler http.HandlerFunc) http.HandlerFunc {
+	protected := a.basicAuth(handler, a.config.user, a.config.pass, a.config.appName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.skipsAuth(r) {
+			handler(w, r)
+			return
+		}
+		if !a.hasShareToken(r) {
+			protected(w, r)
+			return
+		}
+		if r.URL.Query().Has("share") {
+			http.SetCookie(w, &http.Cookie{
+				Name:     shareCookieName,
+				Value:    a.config.shareToken,
+				Path:     a.config.basePath + "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		handler(w, r)
+	}
+}
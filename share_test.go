@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestShareToken(t *testing.T) {
+	// Test that the share token grants the live view but none of the other endpoints
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.shareToken = "sharetoken"
+	ts := httptest.NewServer(a.routes())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	do := func(method, path string) int {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(""))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := do("GET", "/?share=wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for wrong share token, got %d", code)
+	}
+	if code := do("GET", "/?share=sharetoken"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for map page, got %d", code)
+	}
+	// Assets are loaded with the cookie set by the map page
+	if code := do("GET", "/script.js"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for assets with share cookie, got %d", code)
+	}
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?share=sharetoken", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial with share token failed: %v", err)
+	}
+	expectMeta(t, c)
+	c.Close()
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/api/history?share=sharetoken"},
+		{"GET", "/api/stats?share=sharetoken"},
+		{"DELETE", "/api/locations?share=sharetoken"},
+		{"GET", "/export/gpx?share=sharetoken"},
+		{"POST", "/import/gpx?share=sharetoken"},
+		{"GET", "/track?token=sharetoken&lat=1&lon=2&timestamp=3"},
+		{"POST", "/track?share=sharetoken"},
+	} {
+		if code := do(tc.method, tc.path); code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for %s %s, got %d", tc.method, tc.path, code)
+		}
+	}
+	var count int
+	a.db.QueryRow("SELECT COUNT(*) FROM locations").Scan(&count)
+	if count != 0 {
+		t.Fatalf("Expected no stored locations, got %d", count)
+	}
+}
+
+func TestShareTokenDisabled(t *testing.T) {
+	// Test that an empty share token never grants access
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(a.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/?share=", "/ws?share="} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebSocketQueryTokenAuth(t *testing.T) {
+	// Test that /ws accepts the share token as query parameter and rejects write and invalid tokens
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.shareToken = "sharetoken"
+	ts := httptest.NewServer(a.routes())
+	defer ts.Close()
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	for _, query := range []string{"?token=sharetoken", "?share=sharetoken"} {
+		c, _, err := gwss.DefaultDialer.Dial(u+query, nil)
+		if err != nil {
+			t.Fatalf("WebSocket dial with %s failed: %v", query, err)
+		}
+		expectMeta(t, c)
+		c.Close()
+	}
+	for _, query := range []string{"?token=testtoken", "?token=wrong", "?token=", "?share=wrong", ""} {
+		_, resp, err := gwss.DefaultDialer.Dial(u+query, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for %q, got %v", query, err)
+		}
+		if query != "" && resp.Header.Get("WWW-Authenticate") != "" {
+			t.Fatalf("Expected no basic authentication challenge for %q", query)
+		}
+	}
+
+	// The default token is rejected even when it is configured as share token
+	a.config.token = "default"
+	a.config.shareToken = "default"
+	if _, resp, err := gwss.DefaultDialer.Dial(u+"?token=default", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for the default token, got %v", err)
+	}
+}
+
+func TestAuthSkipNetworks(t *testing.T) {
+	// Test that clients from exempt networks skip basic authentication of the live view only
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.authSkipNetworks, _ = parseNetworks("192.168.1.0/24")
+	a.config.trustedProxies, _ = parseNetworks("10.0.0.1")
+	handler := a.routes()
+	do := func(method, path, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, path := range []string{"/", "/api/config", "/api/last"} {
+		if code := do("GET", path, "192.168.1.20:5000", ""); code == http.StatusUnauthorized {
+			t.Fatalf("Expected %s to skip authentication inside the network", path)
+		}
+		if code := do("GET", path, "192.168.2.20:5000", ""); code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for %s outside the network, got %d", path, code)
+		}
+	}
+	// Forwarding headers only count when sent by a trusted proxy
+	if code := do("GET", "/api/config", "203.0.113.5:5000", "192.168.1.20"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a spoofed forwarding header, got %d", code)
+	}
+	if code := do("GET", "/api/config", "10.0.0.1:5000", "192.168.1.20"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for a client forwarded by a trusted proxy, got %d", code)
+	}
+	// The REST API and tracking keep their own authentication
+	if code := do("GET", "/api/history", "192.168.1.20:5000", ""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for the REST API inside the network, got %d", code)
+	}
+	if code := do("GET", "/track?lat=1&lon=2&timestamp=1000", "192.168.1.20:5000", ""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for tracking without a token inside the network, got %d", code)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	// The test client connects from 127.0.0.1
+	a.config.authSkipNetworks, _ = parseNetworks("127.0.0.0/8")
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Expected WebSocket without authentication inside the network: %v", err)
+	}
+	c.Close()
+	a.config.authSkipNetworks = nil
+	if _, resp, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for WebSocket outside the network, got %v", err)
+	}
+}
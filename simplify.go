@@ -0,0 +1,256 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Downsampling methods for long histories
+const (
+	downsampleStride   = "stride"
+	downsampleSimplify = "simplify"
+)
+
+// Iterations of the epsilon search when simplifying to a maximum number of points
+const simplifySearchSteps = 30
+
+// Largest accepted minimum interval between points, one day
+const maxMinIntervalSeconds = 86400
+
+// Options to reduce the number of history points, disabled when maxPoints is zero
+type downsampleOptions struct {
+	maxPoints int
+	method    string
+	// Douglas-Peucker tolerance in meters, searched to fit maxPoints when zero
+	epsilon float64
+	// Minimum time between two returned points of a device, applied before maxPoints, disabled when zero
+	minInterval time.Duration
+}
+
+// Helper to parse the optional minInterval (or min_interval) query parameter in seconds
+func parseMinInterval(query url.Values) (time.Duration, error) {
+	s := queryParam(query, "minInterval", "min_interval")
+	if s == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(seconds >= 0) || seconds > maxMinIntervalSeconds {
+		return 0, fmt.Errorf("invalid minInterval")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Helper to parse max_points, downsample, epsilon and min_interval query parameters
+func parseDownsampleOptions(query url.Values) (downsampleOptions, error) {
+	opts := downsampleOptions{method: downsampleStride}
+	minInterval, err := parseMinInterval(query)
+	if err != nil {
+		return opts, err
+	}
+	opts.minInterval = minInterval
+	if s := queryParam(query, "max_points", "maxPoints"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 {
+			return opts, fmt.Errorf("invalid max_points, must be at least 2")
+		}
+		opts.maxPoints = n
+	}
+	if s := query.Get("downsample"); s != "" {
+		if s != downsampleStride && s != downsampleSimplify {
+			return opts, fmt.Errorf("invalid downsample method, use %s or %s", downsampleStride, downsampleSimplify)
+		}
+		opts.method = s
+	}
+	if s := query.Get("epsilon"); s != "" {
+		eps, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(eps >= 0) {
+			return opts, fmt.Errorf("invalid epsilon")
+		}
+		opts.epsilon = eps
+	}
+	return opts, nil
+}
+
+// Helper to get downsampling options of a requested history window, invalid values disable downsampling
+func downsampleOptionsFromWindow(window historyWindow) downsampleOptions {
+	opts := downsampleOptions{method: downsampleStride}
+	if window.MaxPoints >= 2 {
+		opts.maxPoints = window.MaxPoints
+	}
+	if window.Downsample == downsampleSimplify {
+		opts.method = downsampleSimplify
+	}
+	if window.Epsilon > 0 {
+		opts.epsilon = window.Epsilon
+	}
+	if window.MinInterval > 0 && window.MinInterval <= maxMinIntervalSeconds {
+		opts.minInterval = time.Duration(window.MinInterval * float64(time.Second))
+	}
+	return opts
+}
+
+// Keeps the first point of each device and then every point at least minInterval after the last kept
+// point of the same device, for points ordered by timestamp per device
+type intervalThinner struct {
+	minInterval int64
+	lastKept    map[string]int64
+}
+
+func newIntervalThinner(minInterval time.Duration) *intervalThinner {
+	return &intervalThinner{minInterval: minInterval.Milliseconds(), lastKept: make(map[string]int64)}
+}
+
+// Report whether the point is kept, points with the timestamp of the last kept one are dropped
+// and the first point after a gap longer than minInterval is always kept
+func (t *intervalThinner) keep(p locationPoint) bool {
+	if t.minInterval <= 0 {
+		return true
+	}
+	if last, ok := t.lastKept[p.DeviceID]; ok && p.Timestamp-last < t.minInterval {
+		return false
+	}
+	t.lastKept[p.DeviceID] = p.Timestamp
+	return true
+}
+
+// Thin points so consecutive points of a device are at least minInterval apart, keeping the first of each interval
+func thinByInterval(points []locationPoint, minInterval time.Duration) []locationPoint {
+	if minInterval <= 0 {
+		return points
+	}
+	thinner := newIntervalThinner(minInterval)
+	result := make([]locationPoint, 0, len(points))
+	for _, p := range points {
+		if thinner.keep(p) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Reduce the points of each device to a representative subset of at most maxPoints in total,
+// keeping the first and last point of every device and the ascending timestamp order
+func downsample(points []locationPoint, opts downsampleOptions) []locationPoint {
+	points = thinByInterval(points, opts.minInterval)
+	if opts.maxPoints <= 0 || len(points) <= opts.maxPoints {
+		return points
+	}
+	var devices []string
+	byDevice := make(map[string][]locationPoint)
+	for _, p := range points {
+		if _, ok := byDevice[p.DeviceID]; !ok {
+			devices = append(devices, p.DeviceID)
+		}
+		byDevice[p.DeviceID] = append(byDevice[p.DeviceID], p)
+	}
+	result := make([]locationPoint, 0, opts.maxPoints)
+	for _, device := range devices {
+		devicePoints := byDevice[device]
+		// Each device gets a share of the budget according to its number of points
+		budget := max(2, opts.maxPoints*len(devicePoints)/len(points))
+		if opts.method == downsampleSimplify {
+			result = append(result, simplifyToMax(devicePoints, budget, opts.epsilon)...)
+		} else {
+			result = append(result, strideSample(devicePoints, budget)...)
+		}
+	}
+	slices.SortStableFunc(result, func(a, b locationPoint) int {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	})
+	return result
+}
+
+// Select evenly spaced points including the first and the last one
+func strideSample(points []locationPoint, maxPoints int) []locationPoint {
+	if len(points) <= maxPoints {
+		return points
+	}
+	result := make([]locationPoint, 0, maxPoints)
+	step := float64(len(points)-1) / float64(maxPoints-1)
+	for i := range maxPoints {
+		result = append(result, points[int(math.Round(float64(i)*step))])
+	}
+	return result
+}
+
+// Simplify with the given tolerance, or with the smallest tolerance found that fits maxPoints
+func simplifyToMax(points []locationPoint, maxPoints int, epsilon float64) []locationPoint {
+	if epsilon > 0 {
+		return simplify(points, epsilon)
+	}
+	if len(points) <= maxPoints {
+		return points
+	}
+	lo, hi := 0.0, 1.0
+	for len(simplify(points, hi)) > maxPoints {
+		hi *= 2
+		if hi > 2*math.Pi*earthRadiusMeters {
+			break
+		}
+	}
+	for range simplifySearchSteps {
+		mid := (lo + hi) / 2
+		if len(simplify(points, mid)) > maxPoints {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	// Points too close together for any tolerance are reduced further by stride
+	return strideSample(simplify(points, hi), maxPoints)
+}
+
+// Douglas-Peucker simplification, keeps points deviating more than epsilon meters from the simplified line
+func simplify(points []locationPoint, epsilon float64) []locationPoint {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	// Iterative to avoid deep recursion on long tracks
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		maxDist, index := 0.0, -1
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > epsilon {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+	result := make([]locationPoint, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Distance in meters from a point to the segment between a and b, using an equirectangular
+// projection around a, which is accurate enough for the short segments of a track
+func segmentDistance(p, a, b locationPoint) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	cosLat := math.Cos(toRad(a.Latitude))
+	project := func(q locationPoint) (float64, float64) {
+		return toRad(q.Longitude-a.Longitude) * cosLat * earthRadiusMeters, toRad(q.Latitude-a.Latitude) * earthRadiusMeters
+	}
+	px, py := project(p)
+	bx, by := project(b)
+	lengthSquared := bx*bx + by*by
+	if lengthSquared == 0 {
+		return math.Hypot(px, py)
+	}
+	t := max(0, min(1, (px*bx+py*by)/lengthSquared))
+	return math.Hypot(px-t*bx, py-t*by)
+}
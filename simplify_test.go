@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSimplify(t *testing.T) {
+	// Test that Douglas-Peucker removes points close to the line and keeps corners
+	points := []locationPoint{
+		{Latitude: 0, Longitude: 0, Timestamp: 1},
+		{Latitude: 0, Longitude: 0.001, Timestamp: 2},
+		{Latitude: 0.000001, Longitude: 0.002, Timestamp: 3},
+		{Latitude: 0, Longitude: 0.003, Timestamp: 4},
+		{Latitude: 0.003, Longitude: 0.003, Timestamp: 5},
+	}
+	result := simplify(points, 1)
+	var timestamps []int64
+	for _, p := range result {
+		timestamps = append(timestamps, p.Timestamp)
+	}
+	if !slices.Equal(timestamps, []int64{1, 4, 5}) {
+		t.Fatalf("Expected corners to be kept, got %v", timestamps)
+	}
+	if len(simplify(points, 0.01)) != 5 {
+		t.Fatal("Expected all points for a tiny tolerance")
+	}
+	if len(simplify(points[:2], 1000)) != 2 {
+		t.Fatal("Expected short tracks to stay unchanged")
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	// Test that both methods stay within the budget and keep endpoints and order
+	var points []locationPoint
+	for i := range 1000 {
+		device := "phone"
+		if i%4 == 0 {
+			device = "bike"
+		}
+		points = append(points, locationPoint{Latitude: math.Sin(float64(i)/50) * 0.01, Longitude: float64(i) * 0.001, Timestamp: int64(i), DeviceID: device})
+	}
+	for _, method := range []string{downsampleStride, downsampleSimplify} {
+		result := downsample(points, downsampleOptions{maxPoints: 100, method: method})
+		if len(result) > 100 || len(result) < 20 {
+			t.Fatalf("%s: expected up to 100 points, got %d", method, len(result))
+		}
+		if !slices.IsSortedFunc(result, func(a, b locationPoint) int { return int(a.Timestamp - b.Timestamp) }) {
+			t.Fatalf("%s: points are not in ascending order", method)
+		}
+		for _, ts := range []int64{0, 1, 996, 999} {
+			if !slices.ContainsFunc(result, func(p locationPoint) bool { return p.Timestamp == ts }) {
+				t.Fatalf("%s: first or last point of a device missing (%d)", method, ts)
+			}
+		}
+	}
+	if result := downsample(points, downsampleOptions{}); len(result) != 1000 {
+		t.Fatalf("Expected no downsampling without max points, got %d", len(result))
+	}
+}
+
+func TestParseDownsampleOptions(t *testing.T) {
+	// Test that query parameters are parsed and invalid values are rejected
+	opts, err := parseDownsampleOptions(url.Values{"max_points": {"500"}, "downsample": {"simplify"}, "epsilon": {"2.5"}})
+	if err != nil || opts.maxPoints != 500 || opts.method != downsampleSimplify || opts.epsilon != 2.5 {
+		t.Fatalf("Unexpected options %+v, error %v", opts, err)
+	}
+	if opts, err := parseDownsampleOptions(url.Values{}); err != nil || opts.maxPoints != 0 || opts.method != downsampleStride {
+		t.Fatalf("Unexpected default options %+v, error %v", opts, err)
+	}
+	if opts, err := parseDownsampleOptions(url.Values{"min_interval": {"2.5"}}); err != nil || opts.minInterval != 2500*time.Millisecond {
+		t.Fatalf("Unexpected minimum interval %+v, error %v", opts, err)
+	}
+	if opts, err := parseDownsampleOptions(url.Values{"maxPoints": {"300"}}); err != nil || opts.maxPoints != 300 {
+		t.Fatalf("Expected maxPoints to be accepted, got %+v, error %v", opts, err)
+	}
+	if opts, err := parseDownsampleOptions(url.Values{"minInterval": {"30"}}); err != nil || opts.minInterval != 30*time.Second {
+		t.Fatalf("Expected minInterval to be accepted, got %+v, error %v", opts, err)
+	}
+	for _, query := range []url.Values{{"max_points": {"1"}}, {"max_points": {"x"}}, {"maxPoints": {"1"}}, {"downsample": {"random"}}, {"epsilon": {"-1"}}, {"min_interval": {"-5"}}, {"min_interval": {"NaN"}}, {"min_interval": {"86401"}}, {"minInterval": {"-1"}}} {
+		if _, err := parseDownsampleOptions(query); err == nil {
+			t.Fatalf("Expected error for %v", query)
+		}
+	}
+}
+
+func TestThinByInterval(t *testing.T) {
+	// Test that points closer than the minimum interval to the last kept point of their device are dropped
+	var points []locationPoint
+	// Irregular spacing in seconds, including duplicate timestamps and a long gap
+	for _, sec := range []int64{0, 1, 1, 7, 29, 30, 31, 45, 59, 60, 61, 200, 200, 201, 229, 230} {
+		points = append(points, locationPoint{Timestamp: sec * 1000, DeviceID: "phone"})
+	}
+	// A second device is thinned independently
+	points = append(points, locationPoint{Timestamp: 5000, DeviceID: "bike"}, locationPoint{Timestamp: 20000, DeviceID: "bike"}, locationPoint{Timestamp: 36000, DeviceID: "bike"})
+	slices.SortStableFunc(points, func(a, b locationPoint) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+
+	var phone, bike []int64
+	for _, p := range thinByInterval(points, 30*time.Second) {
+		if p.DeviceID == "phone" {
+			phone = append(phone, p.Timestamp/1000)
+		} else {
+			bike = append(bike, p.Timestamp/1000)
+		}
+	}
+	if !slices.Equal(phone, []int64{0, 30, 60, 200, 230}) {
+		t.Fatalf("Unexpected phone points: %v", phone)
+	}
+	if !slices.Equal(bike, []int64{5, 36}) {
+		t.Fatalf("Unexpected bike points: %v", bike)
+	}
+
+	if thinned := thinByInterval(points, 0); len(thinned) != len(points) {
+		t.Fatalf("Expected all points without interval, got %d", len(thinned))
+	}
+	if thinned := thinByInterval(nil, time.Minute); len(thinned) != 0 {
+		t.Fatalf("Expected no points, got %d", len(thinned))
+	}
+	// Thinning happens before downsampling to a maximum number of points
+	if thinned := downsample(points, downsampleOptions{maxPoints: 100, method: downsampleStride, minInterval: time.Minute}); len(thinned) != 4 {
+		t.Fatalf("Expected 3 phone and 1 bike point one minute apart, got %d", len(thinned))
+	}
+}
+
+func TestWebSocketMessageAliases(t *testing.T) {
+	// Test that camelCase field names are accepted in messages and windows, snake_case names win
+	var msg wsClientMessage
+	if err := json.Unmarshal([]byte(`{"type": "get_history", "maxPoints": 100, "windows": [{"maxPoints": 50}, {"max_points": 20, "maxPoints": 30}]}`), &msg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if msg.Type != "get_history" || msg.MaxPoints != 100 || len(msg.Windows) != 2 || msg.Windows[0].MaxPoints != 50 || msg.Windows[1].MaxPoints != 20 {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+	if err := json.Unmarshal([]byte(`{"type": "get_history", "minLat": 1, "maxLat": 2, "minLon": 3, "maxLon": 4}`), &msg); err != nil || boundingBoxFromMessage(msg) == nil {
+		t.Fatalf("Expected camelCase bounding box, got %+v (%v)", msg, err)
+	}
+	if err := json.Unmarshal([]byte(`{"type": "get_history", "minInterval": 30, "windows": [{"minInterval": 60}]}`), &msg); err != nil || msg.MinInterval != 30 || msg.Windows[0].MinInterval != 60 {
+		t.Fatalf("Expected camelCase minimum interval, got %+v (%v)", msg, err)
+	}
+	if err := json.Unmarshal([]byte(`{"type": "get_history", "maxPoints": "many"}`), &msg); err == nil {
+		t.Fatal("Expected error for an invalid aliased value")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Sizes above these are allowed but logged, the page cache is allocated per connection and a memory map
+// larger than the database file only reserves address space
+const (
+	sqliteMmapSizeWarnMB  = 1024
+	sqliteCacheSizeWarnMB = 256
+)
+
+// Connector opening SQLite connections with the configured tuning pragmas, run on every new connection
+// because pragmas like mmap_size only apply to the connection executing them
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Helper to create a connector for the DSN applying the optional mmap and cache size pragmas
+func (a *app) sqliteConnector(dsn string) sqliteConnector {
+	var pragmas []string
+	if a.config.sqliteMmapSizeMB > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d;", a.config.sqliteMmapSizeMB*1024*1024))
+	}
+	if a.config.sqliteCacheSizeMB > 0 {
+		// A negative cache size is in KiB instead of pages
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = -%d;", a.config.sqliteCacheSizeMB*1024))
+	}
+	return sqliteConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		for _, pragma := range pragmas {
+			if _, err := conn.Exec(pragma, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}}
+}
+
+// Helper to log the optional SQLite memory tuning and warn about sizes likely to exhaust the memory of small machines
+func (a *app) logSQLiteTuning() {
+	if a.config.sqliteMmapSizeMB > 0 {
+		log.Printf("SQLite memory map: up to %d MB per connection", a.config.sqliteMmapSizeMB)
+		if a.config.sqliteMmapSizeMB > sqliteMmapSizeWarnMB {
+			log.Printf("WARNING: LIVETRACKER_SQLITE_MMAP_SIZE_MB of %d MB is very large, SQLite may limit it and 32-bit systems can run out of address space", a.config.sqliteMmapSizeMB)
+		}
+	}
+	if a.config.sqliteCacheSizeMB > 0 {
+		log.Printf("SQLite page cache: up to %d MB per connection", a.config.sqliteCacheSizeMB)
+		if a.config.sqliteCacheSizeMB > sqliteCacheSizeWarnMB {
+			log.Printf("WARNING: LIVETRACKER_SQLITE_CACHE_SIZE_MB of %d MB is very large, every pooled connection can use that much memory", a.config.sqliteCacheSizeMB)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteTuningPragmas(t *testing.T) {
+	// Test that mmap and cache size apply to every connection of the pool and stay unchanged when unset
+	a := &app{config: appConfig{sqliteMmapSizeMB: 16, sqliteCacheSizeMB: 8}}
+	dsn := filepath.Join(t.TempDir(), "tracker.db")
+	db := sql.OpenDB(a.sqliteConnector(dsn))
+	defer db.Close()
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for range 2 {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Opening connection failed: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	for i, conn := range conns {
+		var mmapSize, cacheSize int64
+		if err := conn.QueryRowContext(ctx, "PRAGMA mmap_size;").Scan(&mmapSize); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if err := conn.QueryRowContext(ctx, "PRAGMA cache_size;").Scan(&cacheSize); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if mmapSize != 16*1024*1024 || cacheSize != -8*1024 {
+			t.Fatalf("Unexpected pragmas of connection %d: mmap_size %d, cache_size %d", i, mmapSize, cacheSize)
+		}
+	}
+
+	defaults := sql.OpenDB((&app{}).sqliteConnector(dsn))
+	defer defaults.Close()
+	var cacheSize int64
+	if err := defaults.QueryRow("PRAGMA cache_size;").Scan(&cacheSize); err != nil || cacheSize != -2000 {
+		t.Fatalf("Expected SQLite's default cache size, got %d (%v)", cacheSize, err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of pending events per SSE client before further events are dropped
+const sseClientBufferSize = 16
+
+// Registry of Server-Sent Events clients fed by the same broadcasts as the WebSocket hub
+type sseRegistry struct {
+	clients map[chan []byte]struct{}
+	mutex   sync.Mutex
+}
+
+func newSSERegistry() *sseRegistry {
+	return &sseRegistry{clients: make(map[chan []byte]struct{})}
+}
+
+// Register a new client and return the channel its events are delivered on
+func (s *sseRegistry) add() chan []byte {
+	ch := make(chan []byte, sseClientBufferSize)
+	s.mutex.Lock()
+	s.clients[ch] = struct{}{}
+	s.mutex.Unlock()
+	log.Println("SSE client registered")
+	return ch
+}
+
+// Unregister a client, its channel receives no further events
+func (s *sseRegistry) remove(ch chan []byte) {
+	s.mutex.Lock()
+	delete(s.clients, ch)
+	s.mutex.Unlock()
+	log.Println("SSE client unregistered")
+}
+
+// Pass an event to all clients, dropping it for clients that don't keep up
+func (s *sseRegistry) broadcast(data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ch := range s.clients {
+		select {
+		case ch <- data:
+		default:
+			log.Println("SSE client too slow, dropping event")
+		}
+	}
+}
+
+func (a *app) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Stream live location updates as Server-Sent Events
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	clearDeadlines(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := a.hub.sse.add()
+	defer a.hub.sse.remove(events)
+
+	keepalive := make(<-chan time.Time)
+	if a.config.sseKeepaliveInterval > 0 {
+		ticker := time.NewTicker(a.config.sseKeepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.hub.done:
+			return
+		case data := <-events:
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepalive:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err != nil {
+			log.Printf("Error writing to SSE client: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandler(t *testing.T) {
+	// Test that broadcast points are streamed as Server-Sent Events
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.sseKeepaliveInterval = 50 * time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(a.eventsHandler))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	// Wait for a keepalive comment, the client is registered by then
+	readLine := func(prefix string) string {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("Stream closed")
+				}
+				if strings.HasPrefix(line, prefix) {
+					return line
+				}
+			case <-timeout:
+				t.Fatalf("No line starting with %q received", prefix)
+			}
+		}
+	}
+	readLine(": keepalive")
+
+	a.hub.publish(locationPoint{Latitude: 1, Longitude: 2, Timestamp: 1000, DeviceID: "phone"})
+	var p locationPoint
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(readLine("data: "), "data: ")), &p); err != nil {
+		t.Fatalf("Invalid event data: %v", err)
+	}
+	if p.Latitude != 1 || p.Longitude != 2 || p.DeviceID != "phone" {
+		t.Fatalf("Unexpected point: %+v", p)
+	}
+}
+
+func TestEventsHandlerDisconnect(t *testing.T) {
+	// Test that SSE clients are removed from the registry when they disconnect
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(http.HandlerFunc(a.eventsHandler))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	clientCount := func() int {
+		a.hub.sse.mutex.Lock()
+		defer a.hub.sse.mutex.Unlock()
+		return len(a.hub.sse.clients)
+	}
+	waitFor := func(expected int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for clientCount() != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d SSE clients, got %d", expected, clientCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(1)
+	resp.Body.Close()
+	waitFor(0)
+}
@@ -1,6 +1,7 @@
 document.addEventListener('DOMContentLoaded', () => {
     const map = L.map('map').setView([51.505, -0.09], 13);
-    L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+    const defaultTileUrl = 'https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png';
+    let tileLayer = L.tileLayer(defaultTileUrl, {
         maxZoom: 19,
         attribution: '© OpenStreetMap contributors'
     }).addTo(map);
@@ -9,55 +10,148 @@ document.addEventListener('DOMContentLoaded', () => {
     const lastUpdateEl = document.getElementById('lastUpdate');
     const coordsEl = document.getElementById('coords');
     const speedEl = document.getElementById('speed');
+    const batteryEl = document.getElementById('battery');
+    const placeInfoEl = document.getElementById('placeInfo');
+    const placeEl = document.getElementById('place');
 
-    let currentMarker = null;
-    let accuracyCircle = null;
-    let trackPolyline = L.polyline([], { color: 'blue' }).addTo(map);
-    let polylinePoints = [];
+    const trackColors = ['blue', 'red', 'green', 'purple', 'orange', 'darkred', 'cadetblue', 'darkgreen'];
+    const tracks = {};
+    // Online status per device, kept across history reloads
+    const deviceOnline = {};
     let timestampMarkers = [];
     let ws;
+    // Reverse geocoding is rate limited, so the place is looked up at most once a minute
+    let geocode = false;
+    let lastPlaceLookup = 0;
+    // Stable per tab, so the server restores the subscription when the WebSocket reconnects
+    let clientId = sessionStorage.getItem('livetrackerClientId');
+    if (!clientId) {
+        clientId = Math.random().toString(36).slice(2) + Date.now().toString(36);
+        sessionStorage.setItem('livetrackerClientId', clientId);
+    }
+    // Whether the next history only fills the gap of a reconnect instead of replacing the tracks
+    let appendHistory = false;
+    // Timestamp of the newest point received, the cursor for loading only the missed points after a reconnect
+    let lastTimestamp = 0;
+    // Delay before the next reconnect, the server sends a random one when it restarts
+    let reconnectDelay = 5000;
 
-    trackPolyline.on('click', function(e) {
-        if (polylinePoints.length === 0) return;
-        let minDist = Infinity;
-        let closestIdx = 0;
-        for (let i = 0; i < polylinePoints.length; i++) {
-            const latlng = L.latLng(polylinePoints[i].lat, polylinePoints[i].lon);
-            const dist = e.latlng.distanceTo(latlng);
-            if (dist < minDist) {
-                minDist = dist;
-                closestIdx = i;
-            }
+    function getTrack(deviceId) {
+        const id = deviceId || 'default';
+        if (tracks[id]) {
+            return tracks[id];
         }
+        const color = trackColors[Object.keys(tracks).length % trackColors.length];
+        const track = {
+            id: id,
+            color: color,
+            currentMarker: null,
+            accuracyCircle: null,
+            polyline: L.polyline([], { color: color }).addTo(map),
+            points: []
+        };
+        track.polyline.on('click', function(e) {
+            if (track.points.length === 0) return;
+            let minDist = Infinity;
+            let closestIdx = 0;
+            for (let i = 0; i < track.points.length; i++) {
+                const latlng = L.latLng(track.points[i].lat, track.points[i].lon);
+                const dist = e.latlng.distanceTo(latlng);
+                if (dist < minDist) {
+                    minDist = dist;
+                    closestIdx = i;
+                }
+            }
+            timestampMarkers.forEach(m => map.removeLayer(m));
+            timestampMarkers = [];
+            const point = track.points[closestIdx];
+            const marker = L.marker([point.lat, point.lon]).addTo(map)
+                .bindPopup(`${track.id}: ${new Date(point.timestamp).toLocaleString()}${backfillNote(point)}`)
+                .openPopup();
+            timestampMarkers.push(marker);
+        });
+        tracks[id] = track;
+        return track;
+    }
+
+    // Points the server received much later than recorded were buffered by the device
+    function backfillNote(point) {
+        if (!point.received_at || point.received_at - point.timestamp < 60000) return '';
+        return ` (received ${new Date(point.received_at).toLocaleString()})`;
+    }
+
+    function resetTrack(id) {
+        const track = tracks[id];
+        if (!track) return;
+        map.removeLayer(track.polyline);
+        if (track.currentMarker) map.removeLayer(track.currentMarker);
+        if (track.accuracyCircle) map.removeLayer(track.accuracyCircle);
+        delete tracks[id];
+    }
+
+    function resetTracks() {
+        Object.keys(tracks).forEach(resetTrack);
         timestampMarkers.forEach(m => map.removeLayer(m));
         timestampMarkers = [];
-        const point = polylinePoints[closestIdx];
-        const marker = L.marker([point.lat, point.lon]).addTo(map)
-            .bindPopup(new Date(point.timestamp).toLocaleString())
-            .openPopup();
-        timestampMarkers.push(marker);
-    });
+        lastTimestamp = 0;
+    }
 
     function connectWebSocket() {
-        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        ws = new WebSocket(`${protocol}//${window.location.host}/ws`);
+        // Relative to the page so the server can run under a base path
+        const url = new URL('ws', window.location.href);
+        url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+        // Pass on a token or share token the page was opened with
+        const pageParams = new URLSearchParams(window.location.search);
+        const wsParams = new URLSearchParams();
+        ['token', 'share'].filter(key => pageParams.has(key)).forEach(key => wsParams.set(key, pageParams.get(key)));
+        wsParams.set('clientId', clientId);
+        url.search = wsParams.toString();
+        ws = new WebSocket(url);
 
         ws.onopen = () => {
             statusEl.textContent = 'Connected';
             console.log('WebSocket connected');
-            ws.send(JSON.stringify({ type: 'get_history' }));
         };
 
         ws.onmessage = (event) => {
             try {
                 const data = JSON.parse(event.data);
-                if (data.type === 'update') {
-                    handleLocationUpdate(data.payload);
-                } else if (data.type === 'history' && data.payload.length > 0) {
-                    handleHistory(data.payload);
-                } else if (data.type === 'history' && data.payload.length === 0) {
-                    console.log('No historical data received');
-                    statusEl.textContent = 'Connected (no history)';
+                if (data.type === 'meta') {
+                    console.log('Units:', data.units);
+                    (data.devices || []).forEach(handleStatus);
+                    handleConnected(data.restored);
+                } else if (data.type === 'status') {
+                    handleStatus(data.payload);
+                } else if (data.type === 'update') {
+                    // Inaccurate fixes are stored flagged but not drawn
+                    if (!data.payload.low_quality) handleLocationUpdate(data.payload);
+                } else if (data.type === 'still_here') {
+                    // The device hasn't moved, only the time of the last update changes
+                    lastUpdateEl.textContent = `${new Date(data.payload.timestamp).toLocaleString()} (${data.payload.device_id || 'default'})`;
+                } else if (data.type === 'history') {
+                    handleHistoryChunk(data);
+                } else if (data.type === 'error') {
+                    console.warn('Server could not handle a message:', data.message);
+                } else if (data.type === 'reconnect') {
+                    // The server is restarting, all clients reconnecting at once would slow down its start
+                    reconnectDelay = data.afterMs;
+                } else if (data.type === 'deleted') {
+                    console.log('Locations were deleted, reloading history');
+                    resetTracks();
+                    ws.send(JSON.stringify({ type: 'get_history' }));
+                } else if (data.type === 'reset') {
+                    // Sent after locations were deleted or when a device starts a new trip,
+                    // deletes are already handled by the deleted message sent right before
+                    if (data.reason === 'deleted') return;
+                    console.log(`Clearing ${data.device_id || 'all'} tracks (${data.reason})`);
+                    if (data.device_id) {
+                        resetTrack(data.device_id);
+                    } else {
+                        resetTracks();
+                    }
+                    if (data.reload) {
+                        ws.send(JSON.stringify({ type: 'get_history' }));
+                    }
                 }
             } catch (e) {
                 console.error('Error parsing WebSocket message:', e);
@@ -65,9 +159,11 @@ document.addEventListener('DOMContentLoaded', () => {
         };
 
         ws.onclose = () => {
-            statusEl.textContent = 'Disconnected. Reconnecting in 5s...';
-            console.log('WebSocket disconnected. Reconnecting in 5 seconds...');
-            setTimeout(connectWebSocket, 5000);
+            const delay = reconnectDelay;
+            reconnectDelay = 5000;
+            statusEl.textContent = `Disconnected. Reconnecting in ${Math.ceil(delay / 1000)}s...`;
+            console.log(`WebSocket disconnected. Reconnecting in ${delay} ms...`);
+            setTimeout(connectWebSocket, delay);
         };
 
         ws.onerror = (error) => {
@@ -77,64 +173,163 @@ document.addEventListener('DOMContentLoaded', () => {
         };
     }
 
+    // Subscribe and load the history, after a reconnect with a restored subscription only the missed points are loaded
+    function handleConnected(restored) {
+        if (restored && lastTimestamp > 0) {
+            console.log('Subscription restored, loading missed points');
+            appendHistory = true;
+            ws.send(JSON.stringify({
+                type: 'get_history',
+                seconds: Math.ceil((Date.now() - lastTimestamp) / 1000) + 1,
+                sinceTimestamp: lastTimestamp
+            }));
+            return;
+        }
+        // Optionally only show some devices, e.g. ?devices=phone,bike
+        const devices = new URLSearchParams(window.location.search).get('devices');
+        if (devices && !restored) {
+            ws.send(JSON.stringify({ type: 'subscribe', devices: devices.split(',').map(d => d.trim()).filter(d => d) }));
+        }
+        appendHistory = false;
+        ws.send(JSON.stringify({ type: 'get_history' }));
+    }
+
+    // Dim the marker of devices that stopped sending locations
+    function handleStatus(status) {
+        const id = status.device_id || 'default';
+        deviceOnline[id] = status.online;
+        if (tracks[id] && tracks[id].currentMarker) {
+            tracks[id].currentMarker.setOpacity(status.online ? 1 : 0.5);
+        }
+        console.log(`Device ${id} is ${status.online ? 'online' : 'offline'}`);
+    }
+
     function handleLocationUpdate(point) {
         const latLng = [point.lat, point.lon];
+        const track = getTrack(point.device_id);
         console.log('Live update:', point);
+        lastTimestamp = Math.max(lastTimestamp, point.timestamp);
 
-        if (!currentMarker) {
-            currentMarker = L.marker(latLng).addTo(map)
-                .bindPopup('Current Position')
+        if (!track.currentMarker) {
+            track.currentMarker = L.marker(latLng).addTo(map)
+                .bindPopup(`Current Position (${track.id})`)
                 .openPopup();
             map.setView(latLng, 16);
         } else {
-            currentMarker.setLatLng(latLng);
+            track.currentMarker.setLatLng(latLng);
         }
+        track.currentMarker.setOpacity(deviceOnline[track.id] === false ? 0.5 : 1);
         if (point.hdop) {
-            if (!accuracyCircle) {
-                accuracyCircle = L.circle(latLng, {
+            if (!track.accuracyCircle) {
+                track.accuracyCircle = L.circle(latLng, {
                     radius: point.hdop,
-                    color: 'blue',
-                    fillColor: '#3fa9f5',
+                    color: track.color,
+                    fillColor: track.color,
                     fillOpacity: 0.2,
                     weight: 1
                 }).addTo(map);
             } else {
-                accuracyCircle.setLatLng(latLng);
-                accuracyCircle.setRadius(point.hdop);
+                track.accuracyCircle.setLatLng(latLng);
+                track.accuracyCircle.setRadius(point.hdop);
             }
-        } else if (accuracyCircle) {
-            map.removeLayer(accuracyCircle);
-            accuracyCircle = null;
+        } else if (track.accuracyCircle) {
+            map.removeLayer(track.accuracyCircle);
+            track.accuracyCircle = null;
         }
-        trackPolyline.addLatLng(latLng);
-        polylinePoints.push(point);
+        track.polyline.addLatLng(latLng);
+        track.points.push(point);
         if (document.hidden) {
             map.panTo(latLng);
         }
 
-        lastUpdateEl.textContent = new Date(point.timestamp).toLocaleString();
+        lastUpdateEl.textContent = `${new Date(point.timestamp).toLocaleString()} (${track.id})`;
         coordsEl.textContent = `${point.lat.toFixed(5)}, ${point.lon.toFixed(5)}`;
         if (point.speed !== null && typeof point.speed !== 'undefined') {
             speedEl.textContent = (point.speed * 3.6).toFixed(1); // m/s to km/h
         } else {
             speedEl.textContent = '-';
         }
+        batteryEl.textContent = typeof point.battery === 'number' ? point.battery.toFixed(0) : '-';
+        updatePlace(track.id);
     }
 
-    function handleHistory(points) {
-        console.log(`Received ${points.length} historical points`);
-        const latLngs = points.map(p => [p.lat, p.lon]);
-        trackPolyline.setLatLngs(latLngs);
-        polylinePoints = points.slice(0, -1);
+    function updatePlace(deviceId) {
+        if (!geocode || Date.now() - lastPlaceLookup < 60000) return;
+        lastPlaceLookup = Date.now();
+        fetch(`api/place?device=${encodeURIComponent(deviceId)}`)
+            .then(response => response.status === 200 ? response.json() : Promise.reject(response.status))
+            .then(place => {
+                placeEl.textContent = place.display_name || '-';
+            })
+            .catch(e => console.error('Error loading place:', e));
+    }
 
-        if (points.length > 0) {
-            const lastPoint = points[points.length - 1];
-            handleLocationUpdate(lastPoint);
-            map.fitBounds(trackPolyline.getBounds(), { padding: [50, 50] });
+    function handleHistoryChunk(data) {
+        console.log(`Received history chunk ${data.chunk} with ${data.payload.length} points`);
+        if (data.chunk === 0 && !appendHistory) {
+            resetTracks();
+        }
+        // Render each chunk right away, markers are placed once the last chunk arrived
+        data.payload.forEach(p => { lastTimestamp = Math.max(lastTimestamp, p.timestamp); });
+        data.payload.filter(p => !p.low_quality).forEach(p => {
+            const track = getTrack(p.device_id || 'default');
+            const last = track.points[track.points.length - 1];
+            // Points missed during a reconnect overlap with the ones already shown
+            if (appendHistory && last && p.timestamp <= last.timestamp) return;
+            track.polyline.addLatLng([p.lat, p.lon]);
+            track.points.push(p);
+        });
+        if (!data.last) {
+            return;
+        }
+        // After a reconnect the map keeps its view
+        const appended = appendHistory;
+        appendHistory = false;
+        const bounds = L.latLngBounds([]);
+        Object.values(tracks).forEach(track => {
+            const last = track.points.pop();
+            if (!last) return;
+            // The polyline already contains the last point
+            track.polyline.setLatLngs(track.polyline.getLatLngs().slice(0, -1));
+            handleLocationUpdate(last);
+            bounds.extend(track.polyline.getBounds());
+        });
+        if (appended) {
+            return;
+        }
+        if (bounds.isValid()) {
+            map.fitBounds(bounds, { padding: [50, 50] });
         } else {
+            console.log('No historical data received');
             statusEl.textContent = 'Connected (no history)';
         }
     }
 
+    // Use the configured initial view unless the history already moved the map
+    fetch('api/config')
+        .then(response => response.ok ? response.json() : Promise.reject(response.status))
+        .then(config => {
+            if (Object.keys(tracks).length === 0) {
+                map.setView([config.center_lat, config.center_lon], config.zoom);
+            }
+            if (config.app_name) {
+                document.title = config.app_name;
+            }
+            if (config.geocode) {
+                geocode = true;
+                placeInfoEl.hidden = false;
+                const last = Object.values(tracks).flatMap(track => track.points).sort((a, b) => b.timestamp - a.timestamp)[0];
+                if (last) updatePlace(last.device_id || 'default');
+            }
+            if (config.tile_url && config.tile_url !== defaultTileUrl) {
+                map.removeLayer(tileLayer);
+                tileLayer = L.tileLayer(config.tile_url, {
+                    maxZoom: 19,
+                    attribution: config.tile_attribution
+                }).addTo(map);
+            }
+        })
+        .catch(e => console.error('Error loading config:', e));
+
     connectWebSocket();
-});
\ No newline at end of file
+});
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Mean earth radius in meters used for distance calculations
+const earthRadiusMeters = 6371008.8
+
+// Great-circle distance in meters between two coordinates
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// Summary statistics of a track
+type trackStats struct {
+	Points          int      `json:"points"`
+	DistanceMeters  float64  `json:"distance_m"`
+	DurationSeconds float64  `json:"duration_s"`
+	AvgSpeed        *float64 `json:"avg_speed_mps"`
+	MaxSpeed        *float64 `json:"max_speed_mps"`
+	MinAltitude     *float64 `json:"min_altitude_m"`
+	MaxAltitude     *float64 `json:"max_altitude_m"`
+	AscentMeters    float64  `json:"ascent_m"`
+	DescentMeters   float64  `json:"descent_m"`
+}
+
+// Cumulative elevation gain and loss of points ordered by timestamp, from consecutive known altitudes
+// of each device. Changes are only counted once the altitude moved more than threshold meters away
+// from the last counted altitude, so GPS altitude noise doesn't add up.
+func elevationChange(points []locationPoint, threshold float64) (ascent, descent float64) {
+	// Last counted altitude per device
+	reference := make(map[string]float64)
+	for _, p := range points {
+		if p.Altitude == nil {
+			continue
+		}
+		ref, ok := reference[p.DeviceID]
+		if !ok {
+			reference[p.DeviceID] = *p.Altitude
+			continue
+		}
+		diff := *p.Altitude - ref
+		if math.Abs(diff) <= threshold {
+			continue
+		}
+		if diff > 0 {
+			ascent += diff
+		} else {
+			descent -= diff
+		}
+		reference[p.DeviceID] = *p.Altitude
+	}
+	return ascent, descent
+}
+
+// Compute statistics for points ordered by timestamp, distances and elevation changes are only
+// summed between consecutive points of the same device, elevationThreshold smooths the latter
+func computeStats(points []locationPoint, elevationThreshold float64) trackStats {
+	stats := trackStats{Points: len(points)}
+	if len(points) == 0 {
+		return stats
+	}
+	last := make(map[string]locationPoint)
+	for _, p := range points {
+		if prev, ok := last[p.DeviceID]; ok {
+			stats.DistanceMeters += haversine(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		}
+		last[p.DeviceID] = p
+		if p.Speed != nil && (stats.MaxSpeed == nil || *p.Speed > *stats.MaxSpeed) {
+			stats.MaxSpeed = p.Speed
+		}
+		if p.Altitude != nil {
+			if stats.MinAltitude == nil || *p.Altitude < *stats.MinAltitude {
+				stats.MinAltitude = p.Altitude
+			}
+			if stats.MaxAltitude == nil || *p.Altitude > *stats.MaxAltitude {
+				stats.MaxAltitude = p.Altitude
+			}
+		}
+	}
+	stats.AscentMeters, stats.DescentMeters = elevationChange(points, elevationThreshold)
+	duration := time.Duration(points[len(points)-1].Timestamp-points[0].Timestamp) * time.Millisecond
+	stats.DurationSeconds = duration.Seconds()
+	if stats.DurationSeconds > 0 {
+		avg := stats.DistanceMeters / stats.DurationSeconds
+		stats.AvgSpeed = &avg
+	}
+	return stats
+}
+
+func (a *app) statsHandler(w http.ResponseWriter, r *http.Request) {
+	// Return distance, duration, speed, altitude and elevation change statistics for a time range
+	from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var fromMs, toMs int64
+	if from != nil {
+		fromMs = *from
+	} else {
+		fromMs = time.Now().Add(-time.Duration(a.config.historySeconds) * time.Second).UnixMilli()
+	}
+	if to != nil {
+		toMs = *to
+	}
+
+	points, err := a.queryLocations(r.Context(), fromMs, 0, toMs, nil, 0)
+	if err != nil {
+		log.Printf("Error fetching locations for stats: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, computeStats(points, a.config.elevationThreshold))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHaversine(t *testing.T) {
+	// Test haversine against known distances
+	if d := haversine(50.1, 8.6, 50.1, 8.6); d != 0 {
+		t.Fatalf("Expected 0 for identical points, got %v", d)
+	}
+	// One degree of latitude is about 111.2 km
+	if d := haversine(0, 0, 1, 0); math.Abs(d-111195) > 10 {
+		t.Fatalf("Expected ~111195 m, got %v", d)
+	}
+	// Berlin to Paris is about 878 km
+	if d := haversine(52.5200, 13.4050, 48.8566, 2.3522); math.Abs(d-877500) > 2000 {
+		t.Fatalf("Expected ~877.5 km, got %v", d)
+	}
+	if d := haversine(0, -179.5, 0, 179.5); math.Abs(d-111195) > 10 {
+		t.Fatalf("Expected antimeridian crossing to be short, got %v", d)
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	// Test aggregation with null altitude and speed values
+	f := func(v float64) *float64 { return &v }
+	points := []locationPoint{
+		{Latitude: 0, Longitude: 0, Timestamp: 0, Altitude: f(100), Speed: f(2)},
+		{Latitude: 1, Longitude: 0, Timestamp: 100_000},
+		{Latitude: 1, Longitude: 0, Timestamp: 200_000, Altitude: f(50), Speed: f(5)},
+	}
+	stats := computeStats(points, 0)
+	if stats.Points != 3 || math.Abs(stats.DistanceMeters-111195) > 10 || stats.DurationSeconds != 200 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if *stats.MaxSpeed != 5 || *stats.MinAltitude != 50 || *stats.MaxAltitude != 100 || stats.AscentMeters != 0 || stats.DescentMeters != 50 {
+		t.Fatalf("Unexpected aggregations: %+v", stats)
+	}
+	if math.Abs(*stats.AvgSpeed-stats.DistanceMeters/200) > 1e-9 {
+		t.Fatalf("Unexpected average speed: %v", *stats.AvgSpeed)
+	}
+
+	empty := computeStats(nil, 0)
+	if empty.Points != 0 || empty.AvgSpeed != nil || empty.MaxSpeed != nil || empty.MinAltitude != nil {
+		t.Fatalf("Unexpected empty stats: %+v", empty)
+	}
+}
+
+func TestElevationChange(t *testing.T) {
+	// Test that altitude noise within the threshold is ignored while real climbs and descents add up
+	f := func(v float64) *float64 { return &v }
+	var points []locationPoint
+	// Noisy climb from 100 to 130 m and back down to 110 m, with a gap and a second device in between
+	for i, alt := range []float64{100, 102, 99, 101, 98, 104, 110, 108, 113, 120, 118, 125, 130, 127, 131, 124, 115, 117, 110} {
+		points = append(points, locationPoint{Timestamp: int64(i), Altitude: f(alt), DeviceID: "phone"})
+		if i == 5 {
+			points = append(points, locationPoint{Timestamp: int64(i)}, locationPoint{Timestamp: int64(i), Altitude: f(500), DeviceID: "bike"})
+		}
+	}
+
+	for _, tc := range []struct {
+		threshold       float64
+		ascent, descent float64
+	}{
+		// Every change counts without smoothing
+		{0, 46, 36},
+		// 100 -> 104 -> 110 -> 120 -> 125 -> 130 -> 124 -> 115 -> 110
+		{3, 30, 20},
+		// A change of exactly the threshold (100 -> 110) doesn't count: 100 -> 113 -> 125 -> 110
+		{10, 25, 15},
+		{31, 0, 0},
+	} {
+		ascent, descent := elevationChange(points, tc.threshold)
+		if math.Abs(ascent-tc.ascent) > 1e-9 || math.Abs(descent-tc.descent) > 1e-9 {
+			t.Fatalf("Threshold %v: expected ascent %v and descent %v, got %v and %v", tc.threshold, tc.ascent, tc.descent, ascent, descent)
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	// Test that /api/stats returns JSON statistics for the requested range
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.insertLocationStmt.Exec(0.0, 0.0, nil, nil, nil, nil, 1000, defaultDeviceID, false, nil, nil, false, nil)
+	a.insertLocationStmt.Exec(1.0, 0.0, nil, nil, nil, nil, 11000, defaultDeviceID, false, nil, nil, false, nil)
+	srv := httptest.NewServer(http.HandlerFunc(a.statsHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/stats?from=0&to=20000")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats trackStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if stats.Points != 2 || stats.DurationSeconds != 10 || stats.MaxSpeed != nil {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
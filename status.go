@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval between checks for devices that went offline
+const statusCheckInterval = 10 * time.Second
+
+// Online status of a device, sent in meta and status messages
+type deviceStatus struct {
+	DeviceID string `json:"device_id"`
+	Online   bool   `json:"online"`
+	// Time the last location was received, Unix milliseconds
+	LastSeen int64 `json:"last_seen"`
+}
+
+// Time of the last received location and online state per device
+type deviceStatusTracker struct {
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time
+	online   map[string]bool
+}
+
+// Record a received location, returns true if the device was offline before
+func (t *deviceStatusTracker) seen(deviceID string, at time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.lastSeen == nil {
+		t.lastSeen = make(map[string]time.Time)
+		t.online = make(map[string]bool)
+	}
+	t.lastSeen[deviceID] = at
+	wasOnline := t.online[deviceID]
+	t.online[deviceID] = true
+	return !wasOnline
+}
+
+// Mark devices without locations within the threshold as offline and return their status
+func (t *deviceStatusTracker) expire(now time.Time, threshold time.Duration) []deviceStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var changed []deviceStatus
+	for deviceID, online := range t.online {
+		if online && now.Sub(t.lastSeen[deviceID]) > threshold {
+			t.online[deviceID] = false
+			changed = append(changed, deviceStatus{DeviceID: deviceID, LastSeen: t.lastSeen[deviceID].UnixMilli()})
+		}
+	}
+	return changed
+}
+
+// Status of all devices that sent a location since startup, sorted by device ID
+func (t *deviceStatusTracker) snapshot() []deviceStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	statuses := make([]deviceStatus, 0, len(t.lastSeen))
+	for deviceID, lastSeen := range t.lastSeen {
+		statuses = append(statuses, deviceStatus{DeviceID: deviceID, Online: t.online[deviceID], LastSeen: lastSeen.UnixMilli()})
+	}
+	slices.SortFunc(statuses, func(a, b deviceStatus) int { return strings.Compare(a.DeviceID, b.DeviceID) })
+	return statuses
+}
+
+// Record a received location and broadcast a status message if the device came online
+func (a *app) markDeviceSeen(deviceID string) {
+	if a.config.onlineThreshold <= 0 {
+		return
+	}
+	now := time.Now()
+	if a.deviceStatus.seen(deviceID, now) {
+		log.Printf("Device %s is online", deviceID)
+		a.hub.send(hubMessage{Type: "status", Payload: deviceStatus{DeviceID: deviceID, Online: true, LastSeen: now.UnixMilli()}, deviceID: deviceID})
+	}
+}
+
+// Broadcast status messages for devices that went offline
+func (a *app) checkDeviceStatus(now time.Time) {
+	for _, status := range a.deviceStatus.expire(now, a.config.onlineThreshold) {
+		log.Printf("Device %s is offline", status.DeviceID)
+		a.hub.send(hubMessage{Type: "status", Payload: status, deviceID: status.DeviceID})
+	}
+}
+
+func (a *app) runStatusChecker() {
+	// Periodically detect devices that stopped sending locations until the hub shuts down
+	if a.config.onlineThreshold <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(statusCheckInterval, a.config.onlineThreshold))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.hub.done:
+			return
+		case now := <-ticker.C:
+			a.checkDeviceStatus(now)
+		}
+	}
+}
+
+// Overview of a device returned by the devices endpoint
+type deviceSummary struct {
+	DeviceID string `json:"device_id"`
+	Online   bool   `json:"online"`
+	// Time the last location was received, Unix milliseconds
+	LastSeen int64         `json:"last_seen"`
+	Last     locationPoint `json:"last"`
+}
+
+// Query the newest point of every device, SQLite takes the bare columns from the row with the maximum
+func (a *app) queryLastPerDevice(ctx context.Context) ([]locationPoint, error) {
+	ctx, cancel := a.dbContext(ctx)
+	defer cancel()
+	rows, err := a.reader().QueryContext(ctx, "SELECT "+locationColumns+" FROM (SELECT *, MAX(timestamp) FROM locations GROUP BY device_id) ORDER BY device_id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := []locationPoint{}
+	for rows.Next() {
+		p, err := scanLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (a *app) devicesHandler(w http.ResponseWriter, r *http.Request) {
+	// Return every device that reported a location with its last point and online status
+	points, err := a.queryLastPerDevice(r.Context())
+	if err != nil {
+		log.Printf("Error querying devices: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	statuses := make(map[string]deviceStatus)
+	for _, status := range a.deviceStatus.snapshot() {
+		statuses[status.DeviceID] = status
+	}
+	now := time.Now().UnixMilli()
+	devices := make([]deviceSummary, 0, len(points))
+	for _, p := range points {
+		summary := deviceSummary{DeviceID: p.DeviceID, Last: p}
+		if p.ReceivedAt != nil {
+			summary.LastSeen = *p.ReceivedAt
+		}
+		// Devices seen since startup use the tracked status, others are judged by the stored receive time
+		if status, ok := statuses[p.DeviceID]; ok && status.LastSeen >= summary.LastSeen {
+			summary.LastSeen, summary.Online = status.LastSeen, status.Online
+		} else {
+			summary.Online = a.config.onlineThreshold > 0 && now-summary.LastSeen <= a.config.onlineThreshold.Milliseconds()
+		}
+		devices = append(devices, summary)
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestDeviceStatusTracker(t *testing.T) {
+	// Test that devices come online when seen and go offline after the threshold
+	var tracker deviceStatusTracker
+	start := time.Now()
+	if !tracker.seen("phone", start) {
+		t.Fatal("Expected first location to bring the device online")
+	}
+	if tracker.seen("phone", start.Add(time.Second)) {
+		t.Fatal("Expected no transition for an online device")
+	}
+	tracker.seen("bike", start.Add(time.Minute))
+
+	changed := tracker.expire(start.Add(90*time.Second), time.Minute)
+	if len(changed) != 1 || changed[0].DeviceID != "phone" || changed[0].Online {
+		t.Fatalf("Expected phone to go offline, got %+v", changed)
+	}
+	if changed := tracker.expire(start.Add(90*time.Second), time.Minute); len(changed) != 0 {
+		t.Fatalf("Expected offline transition only once, got %+v", changed)
+	}
+	statuses := tracker.snapshot()
+	if len(statuses) != 2 || statuses[0].DeviceID != "bike" || !statuses[0].Online || statuses[1].DeviceID != "phone" || statuses[1].Online {
+		t.Fatalf("Unexpected snapshot: %+v", statuses)
+	}
+	if !tracker.seen("phone", start.Add(2*time.Minute)) {
+		t.Fatal("Expected offline device to come online again")
+	}
+}
+
+func TestDeviceStatusMessages(t *testing.T) {
+	// Test that status transitions are broadcast and included in the meta message
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.onlineThreshold = time.Minute
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+
+	if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: 2, Timestamp: 1000, DeviceID: "phone"}); err != nil {
+		t.Fatalf("Storing location failed: %v", err)
+	}
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer c.Close()
+	var meta metaMessage
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := c.ReadJSON(&meta); err != nil {
+		t.Fatalf("Reading meta message failed: %v", err)
+	}
+	if len(meta.Devices) != 1 || meta.Devices[0].DeviceID != "phone" || !meta.Devices[0].Online {
+		t.Fatalf("Expected phone online in meta, got %+v", meta.Devices)
+	}
+
+	a.checkDeviceStatus(time.Now().Add(2 * time.Minute))
+	var reply struct {
+		Type    string       `json:"type"`
+		Payload deviceStatus `json:"payload"`
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	// Skip the broadcasts of the stored location if they are delivered late
+	for reply.Type != "status" || reply.Payload.Online {
+		if err := c.ReadJSON(&reply); err != nil {
+			t.Fatalf("Reading offline status failed: %v", err)
+		}
+	}
+	if reply.Payload.DeviceID != "phone" {
+		t.Fatalf("Expected offline status for phone, got %+v", reply)
+	}
+}
+
+func TestDevicesHandler(t *testing.T) {
+	// Test that /api/devices lists the last point and online status of every device
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.onlineThreshold = time.Minute
+	srv := httptest.NewServer(http.HandlerFunc(a.devicesHandler))
+	defer srv.Close()
+
+	get := func() []deviceSummary {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var devices []deviceSummary
+		if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return devices
+	}
+	if devices := get(); devices == nil || len(devices) != 0 {
+		t.Fatalf("Expected empty array, got %+v", devices)
+	}
+
+	// Stored before startup and received long ago
+	a.db.Exec("INSERT INTO locations (latitude, longitude, timestamp, device_id, received_at) VALUES (1, 1, 1000, 'bike', '2020-01-01 00:00:00');")
+	a.db.Exec("INSERT INTO locations (latitude, longitude, timestamp, device_id, received_at) VALUES (2, 2, 3000, 'bike', '2020-01-01 00:00:00');")
+	a.db.Exec("INSERT INTO locations (latitude, longitude, timestamp, device_id, received_at) VALUES (3, 3, 2000, 'bike', '2020-01-01 00:00:00');")
+	a.storeLocation(context.Background(), locationPoint{Latitude: 4, Longitude: 4, Timestamp: 5000, DeviceID: "phone"})
+
+	devices := get()
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %+v", devices)
+	}
+	bike, phone := devices[0], devices[1]
+	if bike.DeviceID != "bike" || bike.Last.Latitude != 2 || bike.Last.Timestamp != 3000 || bike.Online || bike.LastSeen != 1577836800000 {
+		t.Fatalf("Unexpected bike summary: %+v", bike)
+	}
+	if phone.DeviceID != "phone" || phone.Last.Latitude != 4 || !phone.Online || time.Since(time.UnixMilli(phone.LastSeen)) > time.Minute {
+		t.Fatalf("Unexpected phone summary: %+v", phone)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Device tokens loaded from the tokens file, replaced on reload
+type tokenFile struct {
+	mutex   sync.RWMutex
+	devices map[string]string
+}
+
+// Resolve a token from the file to a device ID
+func (f *tokenFile) lookup(token string) (string, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return lookupToken(f.devices, token)
+}
+
+func (f *tokenFile) set(devices map[string]string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.devices = devices
+}
+
+// Parse a tokens file with one id:token entry per line, empty lines and lines starting with # are ignored
+func loadTokensFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	devices := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if err := addDeviceEntry(devices, entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Read the tokens file again, the previous tokens stay active if it is invalid
+func (a *app) reloadTokensFile() error {
+	devices, err := loadTokensFile(a.config.tokensFile)
+	if err != nil {
+		return err
+	}
+	a.tokenFile.set(devices)
+	log.Printf("Loaded %d device token(s) from %s", len(devices), a.config.tokensFile)
+	return nil
+}
+
+func (a *app) runTokensReloader(signals <-chan os.Signal) {
+	// Reload the tokens file whenever a signal arrives
+	for range signals {
+		if err := a.reloadTokensFile(); err != nil {
+			log.Printf("Error reloading tokens file, keeping previous tokens: %v", err)
+		}
+	}
+}
+
+// The default device's API token after a rotation, the configured token applies until then
+type apiTokenState struct {
+	mutex   sync.RWMutex
+	token   string
+	rotated bool
+}
+
+// Return the currently valid API token of the default device
+func (a *app) currentToken() string {
+	a.apiToken.mutex.RLock()
+	defer a.apiToken.mutex.RUnlock()
+	if a.apiToken.rotated {
+		return a.apiToken.token
+	}
+	return a.config.token
+}
+
+// Read a persisted API token, an empty token is returned when the file doesn't exist yet
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// Replace the persisted API token, written to a temporary file first so a crash can't leave a partial token
+func writeTokenFile(path, token string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (a *app) rotateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Replace the API token of the default device with a random one and return it once
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	a.apiToken.mutex.Lock()
+	defer a.apiToken.mutex.Unlock()
+	// Without persisting, a restart would silently bring back the old token
+	if a.config.apiTokenFile != "" {
+		if err := writeTokenFile(a.config.apiTokenFile, token); err != nil {
+			log.Printf("Error persisting rotated API token: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	a.apiToken.token, a.apiToken.rotated = token, true
+	log.Printf("API token rotated by %s", a.clientIP(r))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTokensFile(t *testing.T) {
+	// Test that tokens files are parsed with comments and invalid lines are reported
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Writing tokens file failed: %v", err)
+		}
+		return path
+	}
+
+	devices, err := loadTokensFile(write("valid", "# Devices\nphone:tok1\n\n  bike : tok2  \n"))
+	if err != nil || len(devices) != 2 || devices["tok1"] != "phone" || devices["tok2"] != "bike" {
+		t.Fatalf("Unexpected devices: %v, %v", devices, err)
+	}
+	if _, err := loadTokensFile(write("invalid", "phone:tok1\nbike\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected error for line 2, got %v", err)
+	}
+	if _, err := loadTokensFile(write("duplicate", "phone:tok1\nbike:tok1\n")); err == nil {
+		t.Fatal("Expected error for duplicate token")
+	}
+	if _, err := loadTokensFile(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+}
+
+func TestTokensFileReload(t *testing.T) {
+	// Test that tokens are reloaded on signal and kept when the new file is invalid
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.tokensFile = filepath.Join(t.TempDir(), "tokens")
+	os.WriteFile(a.config.tokensFile, []byte("phone:tok1\n"), 0o600)
+	if err := a.reloadTokensFile(); err != nil {
+		t.Fatalf("Loading tokens file failed: %v", err)
+	}
+	if id, ok := a.deviceForToken("tok1"); !ok || id != "phone" {
+		t.Fatalf("Expected tok1 to resolve to phone, got %q %v", id, ok)
+	}
+	if id, ok := a.deviceForToken(a.config.token); !ok || id != defaultDeviceID {
+		t.Fatal("Expected the single API token to keep working")
+	}
+
+	signals := make(chan os.Signal)
+	defer close(signals)
+	go a.runTokensReloader(signals)
+	waitFor := func(token string, expected bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if _, ok := a.deviceForToken(token); ok == expected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected token %s to be accepted=%v", token, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	os.WriteFile(a.config.tokensFile, []byte("bike:tok2\n"), 0o600)
+	signals <- os.Interrupt
+	waitFor("tok2", true)
+	waitFor("tok1", false)
+
+	os.WriteFile(a.config.tokensFile, []byte("invalid\n"), 0o600)
+	signals <- os.Interrupt
+	// The next signal is only received after the invalid file was handled
+	signals <- os.Interrupt
+	if _, ok := a.deviceForToken("tok2"); !ok {
+		t.Fatal("Expected previous tokens to stay active after an invalid reload")
+	}
+}
+
+func TestRotateToken(t *testing.T) {
+	// Test that a rotated token replaces the old one, is persisted and requires authentication
+	a := setupTestApp(t)
+	defer a.db.Close()
+	dir := t.TempDir()
+	a.config.apiTokenFile = filepath.Join(dir, "token")
+	srv := httptest.NewServer(a.routes())
+	defer srv.Close()
+	oldToken := a.config.token
+
+	rotate := func(auth bool) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/token/rotate", nil)
+		if auth {
+			req.SetBasicAuth(a.config.user, a.config.pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body["token"]
+	}
+	if status, _ := rotate(false); status != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %d", status)
+	}
+
+	status, token := rotate(true)
+	if status != http.StatusOK || len(token) < 32 || token == oldToken {
+		t.Fatalf("Expected a new token, got %d %q", status, token)
+	}
+	if _, ok := a.deviceForToken(oldToken); ok {
+		t.Fatal("Expected the old token to be rejected")
+	}
+	if id, ok := a.deviceForToken(token); !ok || id != defaultDeviceID {
+		t.Fatalf("Expected the new token to be accepted, got %q %v", id, ok)
+	}
+	if persisted, err := readTokenFile(a.config.apiTokenFile); err != nil || persisted != token {
+		t.Fatalf("Expected the token to be persisted, got %q (%v)", persisted, err)
+	}
+
+	// A token that can't be persisted isn't activated
+	a.config.apiTokenFile = filepath.Join(dir, "missing", "token")
+	if status, _ := rotate(true); status != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 when persisting fails, got %d", status)
+	}
+	if _, ok := a.deviceForToken(token); !ok {
+		t.Fatal("Expected the previous token to stay valid")
+	}
+}
+
+func TestReadTokenFile(t *testing.T) {
+	// Test that a missing token file yields no token and the content is trimmed
+	dir := t.TempDir()
+	if token, err := readTokenFile(filepath.Join(dir, "missing")); err != nil || token != "" {
+		t.Fatalf("Expected no token for a missing file, got %q (%v)", token, err)
+	}
+	path := filepath.Join(dir, "token")
+	if err := writeTokenFile(path, "secret"); err != nil {
+		t.Fatalf("Writing token file failed: %v", err)
+	}
+	if token, err := readTokenFile(path); err != nil || token != "secret" {
+		t.Fatalf("Expected secret, got %q (%v)", token, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected token file to be private, got %v (%v)", info.Mode(), err)
+	}
+}
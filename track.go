@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Default maximum size of a tracking request body and query string
+const (
+	defaultMaxTrackBodyBytes  = 64 << 10
+	defaultMaxTrackQueryBytes = 8 << 10
+)
+
+// Location fields only set by the server, ignored in tracking request bodies and unknown in strict mode
+var serverLocationFields = []string{"device_id", "bearing_derived", "low_quality", "received_at"}
+
+// Helper to confirm a stored location, as JSON if the client accepts it and as plain text for OsmAnd otherwise
+func writeTrackResponse(w http.ResponseWriter, r *http.Request, stored locationPoint) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, stored)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Location received"))
+}
+
+func (a *app) trackPostHandler(w http.ResponseWriter, r *http.Request) {
+	// Handle location tracking requests with a JSON body
+	deviceID, ok := a.authenticateDevice(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.config.maxTrackBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var problems []string
+	for _, key := range []string{"lat", "lon", "timestamp"} {
+		if _, ok := fields[key]; !ok {
+			problems = append(problems, fmt.Sprintf("missing field %q", key))
+		}
+	}
+	// The timestamp may also be an ISO 8601 string, the remaining fields are decoded as usual
+	var timestamp int64
+	if raw, ok := fields["timestamp"]; ok {
+		if timestamp, err = parseJSONTimestamp(raw); err != nil {
+			problems = append(problems, `invalid value of field "timestamp"`)
+		}
+		delete(fields, "timestamp")
+	}
+	for _, key := range serverLocationFields {
+		if _, ok := fields[key]; ok && a.config.strictJSON {
+			problems = append(problems, fmt.Sprintf("unknown field %q", key))
+		}
+		delete(fields, key)
+	}
+	problems = append(problems, checkBodyFields[locationPoint](fields, nil, a.config.strictJSON)...)
+	if len(problems) > 0 {
+		writeBodyProblems(w, problems)
+		return
+	}
+	body, _ = json.Marshal(fields)
+	var point locationPoint
+	if err := json.Unmarshal(body, &point); err != nil {
+		http.Error(w, "Invalid location: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The device is always determined by the token, bearings and the quality flag only by the server
+	point.DeviceID = deviceID
+	point.BearingDerived = false
+	point.LowQuality = false
+	point.Timestamp = timestamp
+	if point.Label != nil {
+		point.Label = stringOrNil(*point.Label)
+	}
+	a.normalizeSpeed(&point)
+
+	if err := a.validateLocation(point); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		metricPointsRejected.WithLabelValues("invalid").Inc()
+		return
+	}
+
+	// The device gets its response before the point is broadcast, so a slow broadcast doesn't make it resend
+	point, stored, err := a.insertPoint(r.Context(), point)
+	if err != nil {
+		log.Printf("Error saving location: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeTrackResponse(w, r, point)
+	if stored {
+		http.NewResponseController(w).Flush()
+		a.announceLocation(point)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackPostHandler(t *testing.T) {
+	// Test that POST /track accepts JSON bodies and authenticates via query or header
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(http.HandlerFunc(a.trackPostHandler))
+	defer ts.Close()
+
+	post := func(url, auth, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	body := `{"lat": 50.1, "lon": 8.6, "timestamp": 1680000000, "speed": 2.5}`
+	if status := post(ts.URL+"/track?token="+a.config.token, "", body); status != http.StatusOK {
+		t.Fatalf("Expected 200 with query token, got %d", status)
+	}
+	if status := post(ts.URL+"/track", "Bearer "+a.config.token, body); status != http.StatusOK {
+		t.Fatalf("Expected 200 with bearer token, got %d", status)
+	}
+	if status := post(ts.URL+"/track", "Bearer wrong", body); status != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", status)
+	}
+	if status := post(ts.URL+"/track", "Bearer "+a.config.token, `{"lat": 50.1}`); status != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for missing fields, got %d", status)
+	}
+	if status := post(ts.URL+"/track", "Bearer "+a.config.token, `{"lat": 50.1, "lon": "x", "timestamp": 1}`); status != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for invalid field, got %d", status)
+	}
+	large := `{"lat": 50.1, "lon": 8.6, "timestamp": 1, "pad": "` + strings.Repeat("x", defaultMaxTrackBodyBytes) + `"}`
+	if status := post(ts.URL+"/track", "Bearer "+a.config.token, large); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d", status)
+	}
+	a.config.maxTrackBodyBytes = 32
+	if status := post(ts.URL+"/track", "Bearer "+a.config.token, body); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 with a lowered limit, got %d", status)
+	}
+	a.config.maxTrackBodyBytes = defaultMaxTrackBodyBytes
+
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations WHERE speed = 2.5 AND device_id = ?;", defaultDeviceID).Scan(&count); err != nil || count != 2 {
+		t.Fatalf("Expected 2 stored rows: %v, count=%d", err, count)
+	}
+}
+
+func TestTrackPostHandlerRespondsBeforeBroadcast(t *testing.T) {
+	// Test that POST /track answers right after the insert even while broadcasting the point is stuck
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.hub.recent = newRecentBuffer(10)
+	ts := httptest.NewServer(http.HandlerFunc(a.trackPostHandler))
+	defer ts.Close()
+
+	// Holding the recent buffer mutex wedges publishing the point before it reaches the hub
+	a.hub.recent.mutex.Lock()
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Post(ts.URL+"/track?token=testtoken", "application/json", strings.NewReader(`{"lat": 1, "lon": 2, "timestamp": 1000}`))
+	if err != nil {
+		a.hub.recent.mutex.Unlock()
+		t.Fatalf("Expected a response while the broadcast is stuck: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.hub.recent.mutex.Unlock()
+	if resp.StatusCode != http.StatusOK || string(body) != "Location received" {
+		t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestTrackResponseFormat(t *testing.T) {
+	// Test that /track answers with plain text by default and with the stored point when JSON is accepted
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.deriveBearing = true
+	ts := httptest.NewServer(http.HandlerFunc(a.trackHandler))
+	defer ts.Close()
+
+	track := func(lat, timestamp, accept string) (*http.Response, []byte) {
+		params := url.Values{"token": {a.config.token}, "lat": {lat}, "lon": {"8.6"}, "timestamp": {timestamp}}
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/track?"+params.Encode(), nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		return resp, body
+	}
+
+	if _, body := track("50.1", "1680000000", ""); string(body) != "Location received" {
+		t.Fatalf("Expected plain text response, got %q", body)
+	}
+
+	resp, body := track("50.2", "1680000010", "application/json")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Expected JSON content type, got %q", ct)
+	}
+	var p locationPoint
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", body, err)
+	}
+	if p.Latitude != 50.2 || p.DeviceID != defaultDeviceID || p.Timestamp != 1680000010000 {
+		t.Fatalf("Unexpected stored point: %+v", p)
+	}
+	if !p.BearingDerived || p.Bearing == nil || *p.Bearing != 0 {
+		t.Fatalf("Expected derived northward bearing, got %+v", p)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Control message telling WebSocket clients to clear their rendered tracks
+type resetMessage struct {
+	Type string `json:"type"`
+	// Cause of the reset, "deleted" or "trip"
+	Reason string `json:"reason"`
+	// Device whose track is cleared, all tracks when empty
+	DeviceID string `json:"device_id,omitempty"`
+	// Whether clients should request the history again afterwards
+	Reload bool `json:"reload"`
+}
+
+// Timestamp of the newest stored point per device, used to detect the start of a new trip
+type tripTracker struct {
+	mutex sync.Mutex
+	last  map[string]int64
+}
+
+// Check whether a stored point starts a new trip of its device, i.e. more than the trip gap passed
+// since the newest point stored since startup. Late points neither start a trip nor replace newer ones.
+func (a *app) startsTrip(p locationPoint) bool {
+	a.tripState.mutex.Lock()
+	defer a.tripState.mutex.Unlock()
+	if a.tripState.last == nil {
+		a.tripState.last = make(map[string]int64)
+	}
+	prev, ok := a.tripState.last[p.DeviceID]
+	if ok && p.Timestamp <= prev {
+		return false
+	}
+	a.tripState.last[p.DeviceID] = p.Timestamp
+	return ok && time.Duration(p.Timestamp-prev)*time.Millisecond > a.config.tripGap
+}
+
+// A part of a device's track without idle gaps
+type trip struct {
+	DeviceID string `json:"device_id"`
+	// Unix milliseconds of the first and last point
+	Start          int64   `json:"start"`
+	End            int64   `json:"end"`
+	Points         int     `json:"points"`
+	DistanceMeters float64 `json:"distance_m"`
+}
+
+// Split points ordered by timestamp into trips per device, a new trip starts whenever
+// more than gap passed since the previous point of the same device. Trips are ordered by their start.
+func splitTrips(points []locationPoint, gap time.Duration) []trip {
+	trips := []trip{}
+	// Index of the current trip and the previous point of each device
+	current := make(map[string]int)
+	last := make(map[string]locationPoint)
+	for _, p := range points {
+		prev, ok := last[p.DeviceID]
+		if !ok || time.Duration(p.Timestamp-prev.Timestamp)*time.Millisecond > gap {
+			current[p.DeviceID] = len(trips)
+			trips = append(trips, trip{DeviceID: p.DeviceID, Start: p.Timestamp})
+		} else {
+			trips[current[p.DeviceID]].DistanceMeters += haversine(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		}
+		t := &trips[current[p.DeviceID]]
+		t.End = p.Timestamp
+		t.Points++
+		last[p.DeviceID] = p
+	}
+	return trips
+}
+
+func (a *app) tripsHandler(w http.ResponseWriter, r *http.Request) {
+	// Return the trips in a time range, optionally of a single device
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var fromMs, toMs int64
+	if from != nil {
+		fromMs = *from
+	} else {
+		fromMs = time.Now().Add(-time.Duration(a.config.historySeconds) * time.Second).UnixMilli()
+	}
+	if to != nil {
+		toMs = *to
+	}
+
+	points, err := a.queryLocations(r.Context(), fromMs, 0, toMs, nil, 0)
+	if err != nil {
+		log.Printf("Error fetching locations for trips: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if device := query.Get("device"); device != "" {
+		points = slices.DeleteFunc(points, func(p locationPoint) bool { return p.DeviceID != device })
+	}
+	writeJSON(w, http.StatusOK, splitTrips(points, a.config.tripGap))
+}
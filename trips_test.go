@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwss "github.com/gorilla/websocket"
+)
+
+func TestSplitTrips(t *testing.T) {
+	// Test that trips are split per device at gaps longer than the threshold
+	minute := time.Minute.Milliseconds()
+	points := []locationPoint{
+		{Latitude: 0, Longitude: 0, Timestamp: 0, DeviceID: "phone"},
+		{Latitude: 0, Longitude: 0, Timestamp: 1 * minute, DeviceID: "bike"},
+		{Latitude: 0, Longitude: 0.01, Timestamp: 5 * minute, DeviceID: "phone"},
+		// Exactly the gap still belongs to the same trip
+		{Latitude: 0, Longitude: 0.02, Timestamp: 15 * minute, DeviceID: "phone"},
+		{Latitude: 1, Longitude: 1, Timestamp: 40 * minute, DeviceID: "phone"},
+		{Latitude: 0, Longitude: 0, Timestamp: 41 * minute, DeviceID: "bike"},
+	}
+	trips := splitTrips(points, 10*time.Minute)
+	if len(trips) != 4 {
+		t.Fatalf("Expected 4 trips, got %+v", trips)
+	}
+	first := trips[0]
+	if first.DeviceID != "phone" || first.Start != 0 || first.End != 15*minute || first.Points != 3 {
+		t.Fatalf("Unexpected first trip: %+v", first)
+	}
+	if want := haversine(0, 0, 0, 0.02); first.DistanceMeters < want-0.01 || first.DistanceMeters > want+0.01 {
+		t.Fatalf("Expected distance %f, got %f", want, first.DistanceMeters)
+	}
+	if trips[1].DeviceID != "bike" || trips[1].Points != 1 || trips[1].DistanceMeters != 0 {
+		t.Fatalf("Unexpected second trip: %+v", trips[1])
+	}
+	if trips[2].DeviceID != "phone" || trips[2].Start != 40*minute || trips[3].DeviceID != "bike" || trips[3].Start != 41*minute {
+		t.Fatalf("Unexpected trip order: %+v", trips)
+	}
+	if trips := splitTrips(nil, time.Minute); trips == nil || len(trips) != 0 {
+		t.Fatalf("Expected empty trips, got %+v", trips)
+	}
+}
+
+func TestTripsHandler(t *testing.T) {
+	// Test that /api/trips lists the trips in a range and filters by device
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.tripGap = 10 * time.Minute
+	srv := httptest.NewServer(http.HandlerFunc(a.tripsHandler))
+	defer srv.Close()
+	minute := time.Minute.Milliseconds()
+	for _, p := range []locationPoint{
+		{Timestamp: 1000, DeviceID: "phone"},
+		{Timestamp: 1000 + minute, DeviceID: "phone"},
+		{Timestamp: 1000 + 30*minute, DeviceID: "phone"},
+		{Timestamp: 2000, DeviceID: "bike"},
+	} {
+		a.insertLocationStmt.Exec(1.0, 2.0, nil, nil, nil, nil, p.Timestamp, p.DeviceID, false, nil, nil, false, nil)
+	}
+
+	get := func(query string) []trip {
+		resp, err := http.Get(srv.URL + "?from=1&" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var trips []trip
+		if err := json.NewDecoder(resp.Body).Decode(&trips); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return trips
+	}
+	if trips := get(""); len(trips) != 3 {
+		t.Fatalf("Expected 3 trips, got %+v", trips)
+	}
+	trips := get("device=phone")
+	if len(trips) != 2 || trips[0].Points != 2 || trips[0].End != 1000+minute || trips[1].Start != 1000+30*minute {
+		t.Fatalf("Unexpected phone trips: %+v", trips)
+	}
+}
+
+func TestResetOnTrip(t *testing.T) {
+	// Test that a device's first point after the trip gap is preceded by a reset of its track
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.tripGap = 30 * time.Minute
+	a.config.resetOnTrip = true
+	ts := httptest.NewServer(http.HandlerFunc(a.wsHandler))
+	defer ts.Close()
+	c, _, err := gwss.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer c.Close()
+	expectMeta(t, c)
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now().Add(-2 * time.Hour).UnixMilli()
+	for _, offset := range []time.Duration{0, 10 * time.Minute, 5 * time.Minute, time.Hour} {
+		if _, err := a.storeLocation(context.Background(), locationPoint{Latitude: 1, Longitude: 2, Timestamp: start + offset.Milliseconds(), DeviceID: "phone"}); err != nil {
+			t.Fatalf("Storing location failed: %v", err)
+		}
+	}
+	var types []string
+	var reset resetMessage
+	for range 5 {
+		var msg json.RawMessage
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := c.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		var typed struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(msg, &typed)
+		if typed.Type == "reset" {
+			json.Unmarshal(msg, &reset)
+		}
+		types = append(types, typed.Type)
+	}
+	if strings.Join(types, ",") != "update,update,update,reset,update" {
+		t.Fatalf("Expected a reset before the last update only, got %v", types)
+	}
+	if reset.Reason != "trip" || reset.DeviceID != "phone" || reset.Reload {
+		t.Fatalf("Unexpected reset message: %+v", reset)
+	}
+}
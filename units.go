@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+)
+
+// Canonical units of stored and sent location values
+var canonicalUnits = map[string]string{
+	"altitude": "m",
+	"speed":    "m/s",
+	"bearing":  "deg",
+}
+
+// Factors to convert supported device speed units to m/s, keyed by the upper-cased unit
+var speedUnitFactors = map[string]float64{
+	"M/S":  1,
+	"KM/H": 1 / 3.6,
+	"MPH":  0.44704,
+	"KN":   0.514444,
+}
+
+// Display units of speed and altitude in history and export responses, converted from the canonical units
+type unitSystem struct {
+	Speed    string
+	Altitude string
+	// Factors to multiply canonical m/s and meters with
+	speedFactor    float64
+	altitudeFactor float64
+}
+
+// Supported values of the units query parameter
+var unitSystems = map[string]unitSystem{
+	"metric":   {Speed: "km/h", Altitude: "m", speedFactor: 3.6, altitudeFactor: 1},
+	"imperial": {Speed: "mph", Altitude: "ft", speedFactor: 1 / 0.44704, altitudeFactor: 1 / 0.3048},
+}
+
+// Helper to parse the optional units query parameter, nil keeps the canonical units
+func parseUnits(query url.Values) (*unitSystem, error) {
+	s := query.Get("units")
+	if s == "" {
+		return nil, nil
+	}
+	units, ok := unitSystems[s]
+	if !ok {
+		return nil, errors.New("invalid units")
+	}
+	return &units, nil
+}
+
+// Convert a speed in m/s to the display unit
+func (u *unitSystem) speed(v float64) float64 {
+	return v * u.speedFactor
+}
+
+// Convert an altitude in meters to the display unit
+func (u *unitSystem) altitude(v float64) float64 {
+	return v * u.altitudeFactor
+}
+
+// Return a copy of the point with speed and altitude in the display units, the point is returned
+// unchanged for the canonical units. The values are copied as points may be shared with the
+// recent buffer.
+func (u *unitSystem) convert(p locationPoint) locationPoint {
+	if u == nil {
+		return p
+	}
+	if p.Speed != nil {
+		speed := u.speed(*p.Speed)
+		p.Speed = &speed
+	}
+	if p.Altitude != nil {
+		altitude := u.altitude(*p.Altitude)
+		p.Altitude = &altitude
+	}
+	return p
+}
+
+// Helper to convert all points to the display units in a new slice
+func (u *unitSystem) convertAll(points []locationPoint) []locationPoint {
+	if u == nil {
+		return points
+	}
+	converted := make([]locationPoint, len(points))
+	for i, p := range points {
+		converted[i] = u.convert(p)
+	}
+	return converted
+}
+
+// Message sent to WebSocket clients after connecting, declaring the units of all values
+// and the online status of known devices
+type metaMessage struct {
+	Type    string            `json:"type"`
+	Units   map[string]string `json:"units"`
+	Devices []deviceStatus    `json:"devices,omitempty"`
+	// Whether the subscription of a previous connection with the same client ID was restored
+	Restored     bool     `json:"restored,omitempty"`
+	Subscription []string `json:"subscription,omitempty"`
+}
+
+// Convert the speed of a point received from a device to m/s
+func (a *app) normalizeSpeed(p *locationPoint) {
+	if p.Speed == nil {
+		return
+	}
+	factor, ok := speedUnitFactors[a.config.speedUnit]
+	if !ok || factor == 1 {
+		return
+	}
+	speed := *p.Speed * factor
+	p.Speed = &speed
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSpeed(t *testing.T) {
+	// Test that speeds are converted from the configured unit to m/s
+	for unit, expected := range map[string]float64{"M/S": 36, "KM/H": 10, "MPH": 16.09344, "KN": 18.519984} {
+		a := &app{config: appConfig{speedUnit: unit}}
+		speed := 36.0
+		p := locationPoint{Speed: &speed}
+		a.normalizeSpeed(&p)
+		if math.Abs(*p.Speed-expected) > 1e-9 {
+			t.Fatalf("For %s expected %v, got %v", unit, expected, *p.Speed)
+		}
+	}
+	a := &app{config: appConfig{speedUnit: "KM/H"}}
+	p := locationPoint{}
+	a.normalizeSpeed(&p)
+	if p.Speed != nil {
+		t.Fatal("Expected missing speed to stay missing")
+	}
+}
+
+func TestTrackHandlerConvertsSpeed(t *testing.T) {
+	// Test that the track handler stores speeds in m/s
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.speedUnit = "KM/H"
+
+	req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp=1000&speed=36", nil)
+	rec := httptest.NewRecorder()
+	a.trackHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var speed float64
+	if err := a.db.QueryRow("SELECT speed FROM locations").Scan(&speed); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if math.Abs(speed-10) > 1e-9 {
+		t.Fatalf("Expected 10 m/s, got %v", speed)
+	}
+}
+
+func TestUnitConversion(t *testing.T) {
+	// Test converting speed and altitude to metric and imperial units without changing the original point
+	for name, expected := range map[string][2]float64{"metric": {36, 100}, "imperial": {22.369363, 328.083990}} {
+		units, err := parseUnits(url.Values{"units": {name}})
+		if err != nil {
+			t.Fatalf("Parsing %s failed: %v", name, err)
+		}
+		speed, altitude := 10.0, 100.0
+		p := locationPoint{Speed: &speed, Altitude: &altitude}
+		converted := units.convert(p)
+		if math.Abs(*converted.Speed-expected[0]) > 1e-6 || math.Abs(*converted.Altitude-expected[1]) > 1e-6 {
+			t.Fatalf("For %s expected %v, got %v and %v", name, expected, *converted.Speed, *converted.Altitude)
+		}
+		if *p.Speed != 10 || *p.Altitude != 100 {
+			t.Fatalf("Expected the original point to keep canonical units, got %v and %v", *p.Speed, *p.Altitude)
+		}
+		if missing := units.convert(locationPoint{}); missing.Speed != nil || missing.Altitude != nil {
+			t.Fatal("Expected missing values to stay missing")
+		}
+	}
+	if units, err := parseUnits(url.Values{}); err != nil || units != nil {
+		t.Fatalf("Expected canonical units without parameter, got %v (%v)", units, err)
+	}
+	if _, err := parseUnits(url.Values{"units": {"nautical"}}); err == nil {
+		t.Fatal("Expected an error for unknown units")
+	}
+	var canonical *unitSystem
+	speed := 10.0
+	if p := canonical.convert(locationPoint{Speed: &speed}); *p.Speed != 10 {
+		t.Fatalf("Expected canonical units to stay unchanged, got %v", *p.Speed)
+	}
+}
+
+func TestUnitsParameter(t *testing.T) {
+	// Test that history and exports convert values on request and GPX and KML ignore the parameter
+	a := setupTestApp(t)
+	defer a.db.Close()
+	if _, err := a.insertLocationStmt.Exec(1.0, 2.0, 100.0, 10.0, nil, nil, int64(1000), "phone", false, nil, nil, false, nil); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	a.historyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/history?from=0&units=imperial", nil))
+	var points []locationPoint
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &points) != nil || len(points) != 1 {
+		t.Fatalf("Expected history, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if math.Abs(*points[0].Speed-22.369363) > 1e-6 || math.Abs(*points[0].Altitude-328.083990) > 1e-6 {
+		t.Fatalf("Expected imperial values, got %v and %v", *points[0].Speed, *points[0].Altitude)
+	}
+
+	rec = httptest.NewRecorder()
+	a.exportCSVHandler(rec, httptest.NewRequest(http.MethodGet, "/export/csv?units=metric", nil))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(records) != 2 || records[1][3] != "100" || records[1][4] != "36" {
+		t.Fatalf("Expected metric CSV, got %v (%v)", records, err)
+	}
+
+	rec = httptest.NewRecorder()
+	a.historyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/history?from=0", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil || *points[0].Speed != 10 {
+		t.Fatalf("Expected canonical values without units, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	a.historyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/history?units=nautical", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for unknown units, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.exportGPXHandler(rec, httptest.NewRequest(http.MethodGet, "/export/gpx?units=imperial", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<ele>100</ele>") {
+		t.Fatalf("Expected a GPX export in meters, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	a.exportKMLHandler(rec, httptest.NewRequest(http.MethodGet, "/export/kml?units=imperial", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "2,1,100") {
+		t.Fatalf("Expected a KML export in meters, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Maximum length of the label of a point
+const maxLabelLength = 64
+
+// Timestamps below this value are treated as seconds. As milliseconds it is in 2001,
+// as seconds far beyond any realistic date, so both magnitudes can be told apart.
+const secondsTimestampLimit = 1_000_000_000_000
+
+// Convert a Unix timestamp in seconds or milliseconds to milliseconds
+func normalizeTimestamp(ts int64) int64 {
+	if ts > -secondsTimestampLimit && ts < secondsTimestampLimit {
+		return ts * 1000
+	}
+	return ts
+}
+
+// Accepted layouts of ISO 8601 timestamps, times without a zone are UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// Parse a timestamp given as Unix seconds, Unix milliseconds or ISO 8601 string into Unix milliseconds
+func parseTimestamp(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return normalizeTimestamp(ts), nil
+	}
+	// An unencoded + of the zone offset arrives as space in query parameters
+	if date, clock, ok := strings.Cut(s, "T"); ok {
+		s = date + "T" + strings.Replace(clock, " ", "+", 1)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixMilli(), nil
+		}
+	}
+	return 0, errors.New("invalid timestamp")
+}
+
+// Helper to parse a JSON timestamp, which is either a number or a string accepted by parseTimestamp
+func parseJSONTimestamp(raw json.RawMessage) (int64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return parseTimestamp(s)
+	}
+	return parseTimestamp(string(raw))
+}
+
+// Validate the coordinates and timestamp of a received location point
+func (a *app) validateLocation(p locationPoint) error {
+	// Written as negated ranges so NaN is rejected as well
+	if !(p.Latitude >= -90 && p.Latitude <= 90) {
+		return errors.New("latitude out of range [-90, 90]")
+	}
+	if !(p.Longitude >= -180 && p.Longitude <= 180) {
+		return errors.New("longitude out of range [-180, 180]")
+	}
+	if p.Label != nil && utf8.RuneCountInString(*p.Label) > maxLabelLength {
+		return fmt.Errorf("label longer than %d characters", maxLabelLength)
+	}
+	if a.config.maxFutureSkew > 0 {
+		if limit := time.Now().Add(a.config.maxFutureSkew).UnixMilli(); p.Timestamp > limit {
+			return fmt.Errorf("timestamp is more than %s in the future", a.config.maxFutureSkew)
+		}
+	}
+	return nil
+}
+
+// Check whether a fix is less accurate than the configured maximum HDOP,
+// fixes without accuracy only count as low-quality when accuracy is required
+func (a *app) isLowQuality(p locationPoint) bool {
+	if a.config.maxHDOP <= 0 {
+		return false
+	}
+	if p.Accuracy == nil {
+		return a.config.requireAccuracy
+	}
+	// Negated so NaN counts as low-quality as well
+	return !(*p.Accuracy <= a.config.maxHDOP)
+}
+
+// Helper to format an optional accuracy for log messages
+func formatHDOP(accuracy *float64) string {
+	if accuracy == nil {
+		return "unknown"
+	}
+	return strconv.FormatFloat(*accuracy, 'f', -1, 64)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateLocation(t *testing.T) {
+	// Test coordinate boundaries and future timestamp rejection
+	a := &app{}
+	valid := [][2]float64{{90, 180}, {-90, -180}, {0, 0}, {89.999999, -179.999999}}
+	for _, c := range valid {
+		if err := a.validateLocation(locationPoint{Latitude: c[0], Longitude: c[1]}); err != nil {
+			t.Fatalf("Expected %v to be valid, got %v", c, err)
+		}
+	}
+	invalid := [][2]float64{{90.000001, 0}, {-91, 0}, {0, 180.5}, {0, -181}, {999, 999}, {math.NaN(), 0}, {0, math.Inf(1)}}
+	for _, c := range invalid {
+		if err := a.validateLocation(locationPoint{Latitude: c[0], Longitude: c[1]}); err == nil {
+			t.Fatalf("Expected %v to be invalid", c)
+		}
+	}
+
+	now := time.Now()
+	if err := a.validateLocation(locationPoint{Timestamp: now.Add(time.Hour).UnixMilli()}); err != nil {
+		t.Fatalf("Expected future timestamp to be accepted without skew limit, got %v", err)
+	}
+	a.config.maxFutureSkew = time.Minute
+	if err := a.validateLocation(locationPoint{Timestamp: now.Add(30 * time.Second).UnixMilli()}); err != nil {
+		t.Fatalf("Expected timestamp within skew to be valid, got %v", err)
+	}
+	if err := a.validateLocation(locationPoint{Timestamp: now.Add(time.Hour).UnixMilli()}); err == nil {
+		t.Fatal("Expected timestamp beyond skew to be invalid")
+	}
+}
+
+func TestTrackHandler_InvalidCoordinates(t *testing.T) {
+	// Test that /track rejects out-of-range coordinates with 400
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(http.HandlerFunc(a.trackHandler))
+	defer ts.Close()
+	params := url.Values{
+		"token":     {a.config.token},
+		"lat":       {"999"},
+		"lon":       {"8.6"},
+		"timestamp": {strconv.FormatInt(time.Now().UnixMilli(), 10)},
+	}
+	resp, err := http.Get(ts.URL + "/track?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM locations;").Scan(&count); err != nil || count != 0 {
+		t.Fatalf("Expected no stored rows: %v, count=%d", err, count)
+	}
+}
+
+func TestNormalizeTimestamp(t *testing.T) {
+	// Test that timestamps in seconds are converted and milliseconds are kept
+	for input, expected := range map[int64]int64{
+		1700000000:    1700000000000,
+		1700000000123: 1700000000123,
+		0:             0,
+		1000:          1000000,
+		999999999999:  999999999999000,
+		1000000000000: 1000000000000,
+	} {
+		if got := normalizeTimestamp(input); got != expected {
+			t.Fatalf("For %d expected %d, got %d", input, expected, got)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	// Test that integer seconds and milliseconds and ISO 8601 strings are converted to milliseconds
+	for input, expected := range map[string]int64{
+		"1700000000":                    1700000000000,
+		"1700000000123":                 1700000000123,
+		" 1700000000 ":                  1700000000000,
+		"2023-11-14T22:13:20Z":          1700000000000,
+		"2023-11-14T22:13:20.123Z":      1700000000123,
+		"2023-11-15T00:13:20+02:00":     1700000000000,
+		"2023-11-15T00:13:20 02:00":     1700000000000,
+		"2023-11-14T17:13:20.5-05:00":   1700000000500,
+		"2023-11-14T22:13:20":           1700000000000,
+		"2023-11-14 22:13:20":           1700000000000,
+		"2023-11-14T22:13:20.123456789": 1700000000123,
+	} {
+		got, err := parseTimestamp(input)
+		if err != nil || got != expected {
+			t.Fatalf("For %q expected %d, got %d (%v)", input, expected, got, err)
+		}
+	}
+	for _, input := range []string{"", "abc", "2023-11-14", "2023-13-14T22:13:20Z", "1700000000.5"} {
+		if _, err := parseTimestamp(input); err == nil {
+			t.Fatalf("Expected %q to be invalid", input)
+		}
+	}
+}
+
+func TestTrackHandlerISOTimestamp(t *testing.T) {
+	// Test that GET and POST /track store ISO 8601 timestamps in milliseconds
+	a := setupTestApp(t)
+	defer a.db.Close()
+	ts := httptest.NewServer(a.routes())
+	defer ts.Close()
+
+	// The + of the offset is not encoded, as many apps send it
+	resp, err := http.Get(ts.URL + "/track?token=testtoken&lat=1&lon=2&timestamp=2023-11-15T00:13:20+02:00")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for GET, got %d", resp.StatusCode)
+	}
+	resp, err = http.Post(ts.URL+"/track?token=testtoken", "application/json", strings.NewReader(`{"lat": 1, "lon": 2, "timestamp": "2023-11-14T22:13:21Z"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for POST, got %d", resp.StatusCode)
+	}
+
+	points, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil || len(points) != 2 || points[0].Timestamp != 1700000000000 || points[1].Timestamp != 1700000001000 {
+		t.Fatalf("Expected both timestamps in milliseconds, got %+v (%v)", points, err)
+	}
+}
+
+func TestTrackHandlerTimestampInSeconds(t *testing.T) {
+	// Test that a timestamp in seconds is stored in milliseconds and shows up in the history window
+	a := setupTestApp(t)
+	defer a.db.Close()
+	now := time.Now().Unix()
+	req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp="+strconv.FormatInt(now, 10), nil)
+	rec := httptest.NewRecorder()
+	a.trackHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	since := time.Now().Add(-time.Duration(a.config.historySeconds) * time.Second).UnixMilli()
+	points, err := a.queryLocations(context.Background(), since, 0, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 1 || points[0].Timestamp != now*1000 {
+		t.Fatalf("Expected point with timestamp %d in history, got %+v", now*1000, points)
+	}
+}
+
+func TestTrackHandlerBatterySatellites(t *testing.T) {
+	// Test that battery and satellites are stored when sent and stay empty otherwise
+	a := setupTestApp(t)
+	defer a.db.Close()
+	for _, query := range []string{"&batt=87.5&sats=9", "", "&batt=full&sats=many"} {
+		req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp=1000"+query, nil)
+		rec := httptest.NewRecorder()
+		a.trackHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %q, got %d", query, rec.Code)
+		}
+	}
+	points, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil || len(points) != 3 {
+		t.Fatalf("Expected 3 points, got %d (%v)", len(points), err)
+	}
+	if points[0].Battery == nil || *points[0].Battery != 87.5 || points[0].Satellites == nil || *points[0].Satellites != 9 {
+		t.Fatalf("Unexpected battery or satellites: %+v", points[0])
+	}
+	for _, p := range points[1:] {
+		if p.Battery != nil || p.Satellites != nil {
+			t.Fatalf("Expected empty battery and satellites, got %+v", p)
+		}
+	}
+}
+
+func TestTrackHandlerLabel(t *testing.T) {
+	// Test that labels are read from the configured parameter, stored and omitted when missing
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.labelParam = "activity"
+	for _, query := range []string{"&activity=walking", "", "&activity=%20", "&label=driving"} {
+		req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp=1000"+query, nil)
+		rec := httptest.NewRecorder()
+		a.trackHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %q, got %d", query, rec.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp=1000&activity="+strings.Repeat("x", maxLabelLength+1), nil)
+	rec := httptest.NewRecorder()
+	a.trackHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a too long label, got %d", rec.Code)
+	}
+
+	points, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil || len(points) != 4 {
+		t.Fatalf("Expected 4 points, got %d (%v)", len(points), err)
+	}
+	if points[0].Label == nil || *points[0].Label != "walking" {
+		t.Fatalf("Unexpected label: %+v", points[0])
+	}
+	for _, p := range points[1:] {
+		if p.Label != nil {
+			t.Fatalf("Expected no label, got %q", *p.Label)
+		}
+	}
+	if body, _ := json.Marshal(points[1]); strings.Contains(string(body), "label") {
+		t.Fatalf("Expected the label to be omitted, got %s", body)
+	}
+}
+
+func TestIsLowQuality(t *testing.T) {
+	// Test fixes just below, at and above the maximum HDOP as well as fixes without accuracy
+	a := &app{}
+	hdop := func(v float64) *float64 { return &v }
+	if a.isLowQuality(locationPoint{Accuracy: hdop(1000)}) {
+		t.Fatal("Expected no filtering without a maximum HDOP")
+	}
+	a.config.maxHDOP = 20
+	for _, tc := range []struct {
+		accuracy *float64
+		strict   bool
+		want     bool
+	}{
+		{hdop(19.9), false, false},
+		{hdop(20), false, false},
+		{hdop(20.1), false, true},
+		{hdop(math.NaN()), false, true},
+		{nil, false, false},
+		{nil, true, true},
+		{hdop(5), true, false},
+	} {
+		a.config.requireAccuracy = tc.strict
+		if got := a.isLowQuality(locationPoint{Accuracy: tc.accuracy}); got != tc.want {
+			t.Fatalf("isLowQuality(%s, strict %v) = %v, want %v", formatHDOP(tc.accuracy), tc.strict, got, tc.want)
+		}
+	}
+}
+
+func TestTrackHandlerLowQuality(t *testing.T) {
+	// Test that fixes above the maximum HDOP are dropped or stored flagged depending on the mode
+	a := setupTestApp(t)
+	defer a.db.Close()
+	a.config.maxHDOP = 20
+	track := func(hdop string, ts int) {
+		req := httptest.NewRequest(http.MethodGet, "/track?token=testtoken&lat=1&lon=2&timestamp="+strconv.Itoa(ts)+"&hdop="+hdop, nil)
+		rec := httptest.NewRecorder()
+		a.trackHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for HDOP %s, got %d", hdop, rec.Code)
+		}
+	}
+
+	a.config.lowQualityMode = "DROP"
+	track("19.9", 1000)
+	track("20.1", 2000)
+	track("", 3000)
+	a.config.lowQualityMode = "FLAG"
+	track("20.1", 4000)
+
+	points, err := a.queryLocations(context.Background(), 0, 0, 0, nil, 0)
+	if err != nil || len(points) != 3 {
+		t.Fatalf("Expected 3 points, got %+v (%v)", points, err)
+	}
+	for i, want := range []struct {
+		timestamp  int64
+		lowQuality bool
+	}{{1000000, false}, {3000000, false}, {4000000, true}} {
+		if points[i].Timestamp != want.timestamp || points[i].LowQuality != want.lowQuality {
+			t.Fatalf("Expected point %d at %d with low quality %v, got %+v", i, want.timestamp, want.lowQuality, points[i])
+		}
+	}
+}